@@ -0,0 +1,127 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"wikilite/wikilite"
+)
+
+// relatedLimit is the default number of related articles.
+const relatedLimit = 5
+
+func ArticleGet(ctx context.Context, dbID string, articleID int) (wikilite.ArticleResult, error) {
+	e := engineGet(dbID)
+	if e == nil {
+		return wikilite.ArticleResult{}, fmt.Errorf("database not found: %s", dbID)
+	}
+
+	article, err := e.ArticleGet(ctx, articleID)
+	if err != nil {
+		return article, err
+	}
+	article.Languages = ArticleLanguages(ctx, e, article.Entity)
+
+	return article, nil
+}
+
+// ArticleRelated returns the articles of the same database most similar to
+// articleID.
+func ArticleRelated(ctx context.Context, dbID string, articleID int, limit int) ([]wikilite.SearchResult, error) {
+	e := engineGet(dbID)
+	if e == nil {
+		return nil, fmt.Errorf("database not found: %s", dbID)
+	}
+
+	return e.ArticleRelated(ctx, articleID, limit)
+}
+
+func SectionGet(ctx context.Context, dbID string, sectionID int) (wikilite.SectionResult, error) {
+	e := engineGet(dbID)
+	if e == nil {
+		return wikilite.SectionResult{}, fmt.Errorf("database not found: %s", dbID)
+	}
+
+	return e.SectionGet(ctx, sectionID)
+}
+
+func ArticleGetByEntity(ctx context.Context, entity string, language string) (wikilite.ArticleResult, error) {
+	for _, e := range engines {
+		if language != "" && e.Config().Language != language {
+			continue
+		}
+
+		articleID, err := e.ArticleIDByEntity(ctx, entity)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return wikilite.ArticleResult{}, fmt.Errorf("entity query error: %v", err)
+		}
+
+		return ArticleGet(ctx, e.ID, articleID)
+	}
+
+	return wikilite.ArticleResult{}, fmt.Errorf("article not found")
+}
+
+func ArticleGetByTitle(ctx context.Context, dbID string, title string) (wikilite.ArticleResult, error) {
+	e := engineGet(dbID)
+	if e == nil {
+		return wikilite.ArticleResult{}, fmt.Errorf("database not found: %s", dbID)
+	}
+
+	articleID, err := e.ArticleIDByTitle(ctx, title)
+	if err == sql.ErrNoRows {
+		return wikilite.ArticleResult{}, fmt.Errorf("article not found")
+	} else if err != nil {
+		return wikilite.ArticleResult{}, fmt.Errorf("title query error: %v", err)
+	}
+
+	return ArticleGet(ctx, e.ID, articleID)
+}
+
+func ArticleGetRandom(ctx context.Context, dbID string) (wikilite.ArticleResult, error) {
+	e := engineGet(dbID)
+	if e == nil {
+		return wikilite.ArticleResult{}, fmt.Errorf("database not found: %s", dbID)
+	}
+
+	articleID, err := e.ArticleIDRandom(ctx)
+	if err == sql.ErrNoRows {
+		return wikilite.ArticleResult{}, fmt.Errorf("article not found")
+	} else if err != nil {
+		return wikilite.ArticleResult{}, fmt.Errorf("random query error: %v", err)
+	}
+
+	return ArticleGet(ctx, e.ID, articleID)
+}
+
+// ArticleLanguages lists the same Wikidata entity in the other loaded databases,
+// skipping the ones sharing the source language.
+func ArticleLanguages(ctx context.Context, source *wikilite.Engine, entity string) []wikilite.ArticleLanguage {
+	var languages []wikilite.ArticleLanguage
+
+	if entity == "" {
+		return languages
+	}
+
+	sourceLanguage := source.Config().Language
+	for _, e := range engines {
+		language := e.Config().Language
+		if e == source || language == sourceLanguage {
+			continue
+		}
+		if articleID, err := e.ArticleIDByEntity(ctx, entity); err == nil {
+			languages = append(languages, wikilite.ArticleLanguage{
+				Language: language,
+				DB:       e.ID,
+				ID:       articleID,
+			})
+		}
+	}
+
+	return languages
+}
@@ -0,0 +1,41 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+(function() {
+    const input = document.getElementById('searchInput');
+    const list = document.getElementById('suggestions');
+    let timer = null;
+    let controller = null;
+
+    if (!input || !list) {
+        return;
+    }
+
+    input.addEventListener('input', function() {
+        clearTimeout(timer);
+        timer = setTimeout(suggest, 150);
+    });
+
+    function suggest() {
+        const prefix = input.value.trim();
+        if (controller) {
+            controller.abort();
+        }
+        if (prefix === '') {
+            list.innerHTML = '';
+            return;
+        }
+
+        controller = new AbortController();
+        fetch(`/api/suggest?prefix=${encodeURIComponent(prefix)}&limit=10`, { signal: controller.signal })
+            .then(response => response.json())
+            .then(data => {
+                list.innerHTML = '';
+                (data.results || []).forEach(result => {
+                    const option = document.createElement('option');
+                    option.value = result.title;
+                    list.appendChild(option);
+                });
+            })
+            .catch(() => {});
+    }
+})();
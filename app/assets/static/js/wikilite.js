@@ -5,7 +5,8 @@ window.App = {
     searchInput: document.getElementById('searchInput'),
     searchForm: document.getElementById('searchForm'),
     language: new URLSearchParams(window.location.search).get('language') || 'en',
-    ai: new URLSearchParams(window.location.search).get('ai') === 'true'
+    ai: new URLSearchParams(window.location.search).get('ai') === 'true',
+    cursors: {}
 };
 
 if (App.searchForm) {
@@ -18,13 +19,18 @@ if (App.searchForm) {
     App.searchForm.addEventListener('submit', function(event) {
         submitSearch(event);
     });
+
+    document.getElementById('randomArticle').addEventListener('click', function() {
+        articleLoad('/api/article/random');
+    });
 }
 
-function performSearch(query, type, onComplete) {
+function performSearch(query, type, onComplete, cursor) {
     const endpoint = `/api/search/${type}`;
     const payload = {
         query: query,
-        limit: parseInt(document.getElementById("limit").value)
+        limit: parseInt(document.getElementById("limit").value),
+        cursor: cursor
     };
 
     fetch(endpoint, {
@@ -37,6 +43,10 @@ function performSearch(query, type, onComplete) {
     .then(response => response.json())
     .then(data => {
         if (data.status === 'success') {
+            App.cursors[type] = data.next_cursor;
+            if (data.suggestion) {
+                App.suggestion = data.suggestion;
+            }
             if (data.results) {
                 displayResults(data.results, type);
                 onComplete(data.results);
@@ -72,13 +82,24 @@ function displayResults(results, type) {
             titleLink.className = 'text-decoration-none';
             titleLink.textContent = result.title;
             titleLink.addEventListener('click', () => {
-                articleFetch(result.article_id);
+                articleFetch(result.article_id, result.db, result.section_id);
                 document.getElementById('resultsContainer').classList.add('d-none');
                 document.getElementById('articleContent').classList.remove('d-none');
             });
 
+            if (result.section_title) {
+                const sectionTitle = document.createElement('small');
+                sectionTitle.className = 'text-muted ms-1';
+                sectionTitle.textContent = `› ${result.section_title}`;
+                titleLink.after(sectionTitle);
+            }
+
             const text = document.createElement('p');
-            text.textContent = result.text;
+            if (result.snippet) {
+                text.innerHTML = result.snippet;
+            } else {
+                text.textContent = result.text;
+            }
 
             divContent.appendChild(titleLink);
             divContent.appendChild(text);
@@ -103,6 +124,10 @@ function submitSearch(event) {
         document.getElementById('resultsContainer').querySelector('ol').innerHTML = '';
         document.getElementById('resultsContainer').querySelector('.alert')?.remove();
         document.getElementById('loadingSpinner').classList.remove('d-none');
+        App.query = query;
+        App.cursors = {};
+        App.suggestion = null;
+        moreResultsUpdate();
 
         let completedSearches = 0;
         let totalResults = 0;
@@ -116,14 +141,26 @@ function submitSearch(event) {
 
                 if (completedSearches === searchTypes.length) {
                     document.getElementById('loadingSpinner').classList.add('d-none');
+                    moreResultsUpdate();
 
                     if (totalResults === 0) {
                         const noResults = document.createElement('div');
                         noResults.className = 'alert alert-info';
                         noResults.textContent = `No results found for "${query}"`;
+                        if (App.suggestion) {
+                            const suggestion = document.createElement('a');
+                            suggestion.href = '#';
+                            suggestion.className = 'fst-italic';
+                            suggestion.textContent = App.suggestion;
+                            suggestion.addEventListener('click', (event) => {
+                                App.searchInput.value = suggestion.textContent;
+                                submitSearch(event);
+                            });
+                            noResults.append('. Did you mean ', suggestion, '?');
+                        }
                         document.getElementById('resultsContainer').appendChild(noResults);
                     } else if (totalResults === 1) {
-                        articleFetch(allResults[0].article_id);
+                        articleFetch(allResults[0].article_id, allResults[0].db, allResults[0].section_id);
                         document.getElementById('resultsContainer').classList.add('d-none');
                         document.getElementById('articleContent').classList.remove('d-none');
                     }
@@ -133,15 +170,56 @@ function submitSearch(event) {
     }
 }
 
-async function articleFetch(articleId) {
+function moreResults() {
+    const types = Object.keys(App.cursors).filter(type => App.cursors[type]);
+    let completedSearches = 0;
+
+    document.getElementById('loadingSpinner').classList.remove('d-none');
+    types.forEach(type => {
+        performSearch(App.query, type, () => {
+            completedSearches++;
+            if (completedSearches === types.length) {
+                document.getElementById('loadingSpinner').classList.add('d-none');
+                moreResultsUpdate();
+            }
+        }, App.cursors[type]);
+    });
+}
+
+function moreResultsUpdate() {
+    const container = document.getElementById('resultsContainer');
+    let button = document.getElementById('moreResults');
+
+    if (!button) {
+        button = document.createElement('button');
+        button.id = 'moreResults';
+        button.type = 'button';
+        button.className = 'btn btn-outline-secondary w-100 mt-3';
+        button.innerHTML = '<i class="bi bi-chevron-down"></i>';
+        button.addEventListener('click', moreResults);
+        container.appendChild(button);
+    }
+
+    button.classList.toggle('d-none', !Object.values(App.cursors).some(cursor => cursor));
+}
+
+async function articleFetch(articleId, db, sectionId) {
+    articleLoad(`/api/article?id=${articleId}&db=${encodeURIComponent(db || '')}`, sectionId);
+}
+
+async function articleFetchByEntity(entity, language) {
+    articleLoad(`/api/article?entity=${encodeURIComponent(entity)}&language=${encodeURIComponent(language)}`);
+}
+
+async function articleLoad(url, sectionId) {
     try {
         document.getElementById('loadingSpinner').classList.remove('d-none');
-        const response = await fetch(`/api/article?id=${articleId}`);
+        const response = await fetch(url);
         const data = await response.json();
         
         if (data.status === 'success') {
             App.article = data.article;
-            articleDisplay();
+            articleDisplay(sectionId);
         }
     } catch (error) {
         console.error('Error fetching article:', error);
@@ -150,7 +228,7 @@ async function articleFetch(articleId) {
     }
 }
 
-function articleDisplay() {
+function articleDisplay(sectionId) {
     App.isArticle = true;
     document.title = App.article.title;
     document.getElementById('articleTitle').textContent = App.article.title;
@@ -158,23 +236,49 @@ function articleDisplay() {
     const container = document.getElementById('articleTextContent');
     container.innerHTML = '';
     
-    App.article.sections.forEach((section, sectionIndex) => {
+    App.article.sections.forEach((section) => {
         const sectionDiv = document.createElement('div');
         sectionDiv.className = 'mb-4';
+        sectionDiv.id = `section-${section.id}`;
         
         const title = document.createElement('h2');
         title.textContent = section.title;
-        title.id = `section-${sectionIndex}`;
         sectionDiv.appendChild(title);
 
         const p = document.createElement('p');
         p.textContent = section.content;
-        p.id = `content-${sectionIndex}`;
+        p.id = `content-${section.id}`;
         sectionDiv.appendChild(p);
 
         container.appendChild(sectionDiv);
     });
+
+    if (App.article.languages && App.article.languages.length > 0) {
+        const languagesDiv = document.createElement('div');
+        languagesDiv.className = 'mb-4 text-center small';
+        languagesDiv.textContent = 'Available in: ';
+        App.article.languages.forEach((language, index) => {
+            if (index > 0) {
+                languagesDiv.appendChild(document.createTextNode(', '));
+            }
+            const link = document.createElement('a');
+            link.href = '#';
+            link.textContent = language.language;
+            link.addEventListener('click', (event) => {
+                event.preventDefault();
+                articleFetchByEntity(App.article.entity, language.language);
+            });
+            languagesDiv.appendChild(link);
+        });
+        container.appendChild(languagesDiv);
+    }
     
     document.getElementById('searchSection').classList.add('d-none');
     document.getElementById('articleContent').classList.remove('d-none');
+
+    const matched = sectionId && document.getElementById(`section-${sectionId}`);
+    if (matched) {
+        matched.classList.add('bg-warning-subtle', 'rounded', 'p-2');
+        matched.scrollIntoView({ behavior: 'smooth', block: 'start' });
+    }
 }
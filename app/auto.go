@@ -45,6 +45,7 @@ func autoStart() {
 
 	if os.Getenv("TERMUX_VERSION") != "" {
 		options.dbPath = "/data/data/com.termux/files/home/wikilite.db"
+		options.dbPaths = stringList{options.dbPath}
 	}
 
 	if options.web == false && options.cli == false {
@@ -3,78 +3,157 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
+
+	"wikilite/wikilite"
 )
 
 const Name = "wikilite"
-const Version = "0.27.3"
+const Version = wikilite.Version
 
 type Config struct {
-	aiAnn               bool
-	aiAnnMode           string
-	aiAnnSize           int
-	aiApi               bool
-	aiApiKey            string
-	aiApiUrl            string
-	aiModel             string
-	aiModelImport       string
-	aiModelPrefixSave   string
-	aiModelPrefixSearch string
-	aiThreads           int
-	aiSync              bool
-	cli                 bool
-	dbPath              string
-	dbCompress          bool
-	help                bool
-	language            string
-	limit               int
-	log                 bool
-	logFile             string
-	setup               bool
-	web                 bool
-	webBrowser          bool
-	webHost             string
-	webPort             int
-	webTlsPrivate       string
-	webTlsPublic        string
-	wikiImport          string //https://dumps.wikimedia.org/other/enterprise_html/runs/...
+	aiAnn                 bool
+	aiAnnMode             string
+	aiAnnSize             int
+	aiAnnM                int
+	aiAnnEfConstruction   int
+	aiAnnEfSearch         int
+	aiAnnLists            int
+	aiAnnProbe            int
+	aiAnnSubvectors       int
+	aiApi                 bool
+	aiApiKey              string
+	aiApiUrl              string
+	aiChatApiUrl          string
+	aiChatModel           string
+	aiChatModelImport     string
+	aiModel               string
+	aiModelImport         string
+	aiModelPrefixSave     string
+	aiModelPrefixSearch   string
+	aiRerankApiUrl        string
+	aiRerankModel         string
+	aiRerankModelImport   string
+	aiThreads             int
+	aiSync                bool
+	aiVectorsDrop         bool
+	cacheSize             int
+	cli                   bool
+	dbPath                string
+	dbPaths               stringList
+	dbRelated             bool
+	dbCompress            bool
+	dbStats               bool
+	dbSuggest             bool
+	dbTokenizer           string
+	dbVocabulary          bool
+	dbVocabularyVectors   int
+	help                  bool
+	language              string
+	limit                 int
+	log                   bool
+	logFile               string
+	searchRerank          int
+	searchExpand          bool
+	searchExplain         bool
+	searchRrfK            int
+	searchSnippet         int
+	searchTimeout         time.Duration
+	searchWeightContent   float64
+	searchWeightExpansion float64
+	searchWeightSemantic  float64
+	searchWeightTitle     float64
+	setup                 bool
+	web                   bool
+	webBrowser            bool
+	webHost               string
+	webPort               int
+	webTlsPrivate         string
+	webTlsPublic          string
+	wikiImport            string //https://dumps.wikimedia.org/other/enterprise_html/runs/...
 }
 
 var (
-	ai      bool
-	db      *DBHandler
+	engine  *wikilite.Engine
+	engines []*wikilite.Engine
 	options *Config
 )
 
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func parseConfig() (*Config, error) {
 	options = &Config{}
-	flag.BoolVar(&options.aiAnn, "ai-ann", false, "Produce ANN vectors")
-	flag.StringVar(&options.aiAnnMode, "ai-ann-mode", "", "Approximate Nearest Neighbor mode [mrl/binary]")
+	flag.BoolVar(&options.aiAnn, "ai-ann", false, "Produce ANN vectors, after the embeddings with --ai-sync")
+	flag.StringVar(&options.aiAnnMode, "ai-ann-mode", "", "Approximate Nearest Neighbor mode [mrl/binary/hnsw/ivf/pq]")
 	flag.IntVar(&options.aiAnnSize, "ai-ann-size", 0, "ANN MRL size")
+	flag.IntVar(&options.aiAnnM, "ai-ann-m", 16, "HNSW neighbours per node, 2*M on the bottom layer")
+	flag.IntVar(&options.aiAnnEfConstruction, "ai-ann-ef-construction", 200, "HNSW candidates explored when inserting a vector")
+	flag.IntVar(&options.aiAnnEfSearch, "ai-ann-ef-search", 64, "HNSW candidates explored when searching, higher values trade speed for recall")
+	flag.IntVar(&options.aiAnnLists, "ai-ann-lists", 0, "IVF lists, 0 for the square root of the vectors")
+	flag.IntVar(&options.aiAnnProbe, "ai-ann-probe", 8, "IVF lists searched, higher values trade speed for recall")
+	flag.IntVar(&options.aiAnnSubvectors, "ai-ann-subvectors", 0, "PQ sub-vectors, the bytes stored per vector, 0 for one every 8 dimensions")
 	flag.BoolVar(&options.aiApi, "ai-api", false, "Use API for embeddings generation")
 	flag.StringVar(&options.aiApiKey, "ai-api-key", "", "AI API key")
 	flag.StringVar(&options.aiApiUrl, "ai-api-url", "http://localhost:11434/v1/embeddings", "AI API url")
+	flag.StringVar(&options.aiChatApiUrl, "ai-chat-api-url", "", "AI API url of the chat model, used with --ai-api")
+	flag.StringVar(&options.aiChatModel, "ai-chat-model", "", "AI chat model name")
+	flag.StringVar(&options.aiChatModelImport, "ai-chat-model-import", "", "Import AI chat model from file path")
 	flag.StringVar(&options.aiModel, "ai-model", "", "AI embedding model name")
 	flag.StringVar(&options.aiModelImport, "ai-model-import", "", "Import AI model from file path")
 	flag.StringVar(&options.aiModelPrefixSave, "ai-model-prefix-save", "", "AI embedding model task prefix to import a document")
 	flag.StringVar(&options.aiModelPrefixSearch, "ai-model-prefix-search", "", "AI embedding model task prefix to perform a search")
+	flag.StringVar(&options.aiRerankApiUrl, "ai-rerank-api-url", "", "AI API url of the rerank model, used with --ai-api")
+	flag.StringVar(&options.aiRerankModel, "ai-rerank-model", "", "AI rerank model name")
+	flag.StringVar(&options.aiRerankModelImport, "ai-rerank-model-import", "", "Import AI rerank model from file path")
 	flag.IntVar(&options.aiThreads, "ai-threads", 0, "Embedding generation threads (default all)")
 	flag.BoolVar(&options.aiSync, "ai-sync", false, "Generate embeddings")
+	flag.BoolVar(&options.aiVectorsDrop, "ai-vectors-drop", false, "Delete the full vectors, leaving semantic search to the ANN index")
+
+	flag.IntVar(&options.cacheSize, "cache-size", 1000, "Query embeddings and search result pages kept in memory per database, 20 times as many HNSW nodes, 0 disables the caches")
 
 	flag.BoolVar(&options.cli, "cli", false, "Interactive CLI search")
 
-	flag.StringVar(&options.dbPath, "db", "wikilite.db", "SQLite database path")
+	flag.Var(&options.dbPaths, "db", "SQLite database path or directory, can be repeated for federated search (default \"wikilite.db\")")
 	flag.BoolVar(&options.dbCompress, "db-compress", false, "Compress the database")
+	flag.BoolVar(&options.dbRelated, "db-related", false, "Rebuild the article embeddings used by related articles")
+	flag.BoolVar(&options.dbStats, "db-stats", false, "Show database statistics")
+	flag.BoolVar(&options.dbSuggest, "db-suggest", false, "Rebuild the title autocomplete index")
+	flag.StringVar(&options.dbTokenizer, "db-tokenizer", "", "Rebuild the full text indexes with a tokenizer: auto, unicode61, porter or stem")
+	flag.BoolVar(&options.dbVocabulary, "db-vocabulary", false, "Rebuild the vocabulary and its spelling correction index")
+	flag.IntVar(&options.dbVocabularyVectors, "db-vocabulary-vectors", 0, "Embed this many most frequent vocabulary terms for query expansion")
 
 	flag.StringVar(&options.language, "language", "en", "Language code")
 	flag.IntVar(&options.limit, "limit", 5, "Maximum number of search results")
 	flag.BoolVar(&options.log, "log", false, "Enable logging")
 	flag.StringVar(&options.logFile, "log-file", "", "Log file path")
+	flag.BoolVar(&options.searchExpand, "search-expand", false, "Expand lexical queries with the nearest vocabulary terms by default")
+	flag.BoolVar(&options.searchExplain, "search-explain", false, "Show how every retriever ranked the CLI search results and the time of each search stage")
+	flag.IntVar(&options.searchRerank, "search-rerank", 20, "Top results of the combined search rescored by the rerank model, 0 disables")
+	flag.IntVar(&options.searchRrfK, "search-rrf-k", 60, "Reciprocal rank fusion constant, higher values flatten the rank differences")
+	flag.DurationVar(&options.searchTimeout, "search-timeout", 0, "Maximum duration of a search, the retrievers still running are left out of partial results (default none)")
+	flag.IntVar(&options.searchSnippet, "search-snippet", 32, "Search result excerpt length in words, -1 for the full text")
+	flag.Float64Var(&options.searchWeightContent, "search-weight-content", 1, "Content search weight in the combined ranking")
+	flag.Float64Var(&options.searchWeightExpansion, "search-weight-expansion", 0.5, "Expanded query weight in the combined ranking")
+	flag.Float64Var(&options.searchWeightSemantic, "search-weight-semantic", 1, "Semantic search weight in the combined ranking")
+	flag.Float64Var(&options.searchWeightTitle, "search-weight-title", 1, "Title search weight in the combined ranking")
 	flag.BoolVar(&options.setup, "setup", false, "Download prebuild database")
 	flag.BoolVar(&options.help, "help", false, "This help")
 
@@ -91,7 +170,7 @@ func parseConfig() (*Config, error) {
 		fmt.Println("Copyright:", "2024-2025 by Ubaldo Porcheddu <ubaldo@eja.it>")
 		fmt.Println("Version:", Version)
 		fmt.Printf("Usage: %s [options]\n", os.Args[0])
-		fmt.Println("Options:\n")
+		fmt.Print("Options:\n\n")
 		flag.PrintDefaults()
 		fmt.Println()
 	}
@@ -102,6 +181,13 @@ func parseConfig() (*Config, error) {
 		options.aiThreads = runtime.NumCPU()
 	}
 
+	dbPaths, err := dbPathsExpand(options.dbPaths)
+	if err != nil {
+		return nil, err
+	}
+	options.dbPaths = dbPaths
+	options.dbPath = dbPaths[0]
+
 	return options, nil
 }
 
@@ -141,49 +227,112 @@ func main() {
 		log.SetOutput(io.Discard)
 	}
 
-	db, err = NewDBHandler(options.dbPath)
+	ctx := context.Background()
+
+	engine, err = wikilite.Open(ctx, engineConfig(options.dbPath))
 	if err != nil {
 		log.Fatalf("Error initializing database: %v\n", err)
 	}
-	defer db.Close()
+	defer engine.Close()
+	engines = []*wikilite.Engine{engine}
 
-	if err := aiInit(); err != nil {
-		log.Printf("AI initialization error: %v\n", err)
-	} else {
-		ai = true
+	for _, dbPath := range options.dbPaths[1:] {
+		e, err := wikilite.Open(ctx, engineConfig(dbPath))
+		if err != nil {
+			log.Fatalf("Error initializing database %s: %v\n", dbPath, err)
+		}
+		defer e.Close()
+		engineAppend(e)
 	}
 
-	if options.aiSync || options.wikiImport != "" || options.aiModelImport != "" || options.dbCompress {
-		if err := db.PragmaImportMode(); err != nil {
-			log.Fatalf("Error setting database in import mode: %v\n", err)
+	if options.aiModelImport != "" {
+		if err = engine.ModelImport(ctx, options.aiModelImport); err != nil {
+			log.Fatalf("Error importing model file into the database: %v\n", err)
 		}
+	}
 
-		if options.aiModelImport != "" {
-			if err = db.AiModelImport(options.aiModelImport); err != nil {
-				log.Fatalf("Error importing model file into the database: %v\n", err)
-			}
+	if options.aiRerankModelImport != "" {
+		if err = engine.RerankModelImport(ctx, options.aiRerankModelImport); err != nil {
+			log.Fatalf("Error importing rerank model file into the database: %v\n", err)
 		}
+	}
 
-		if options.wikiImport != "" {
-			if err = WikiImport(options.wikiImport); err != nil {
-				log.Fatalf("Error processing import: %v\n", err)
-			}
+	if options.aiChatModelImport != "" {
+		if err = engine.ChatModelImport(ctx, options.aiChatModelImport); err != nil {
+			log.Fatalf("Error importing chat model file into the database: %v\n", err)
 		}
+	}
 
-		if ai && options.aiSync {
-			if err := db.ProcessEmbeddings(); err != nil {
-				log.Fatalf("Error processing embeddings: %v\n", err)
-			}
+	if options.wikiImport != "" {
+		if err = engine.Import(ctx, options.wikiImport); err != nil {
+			log.Fatalf("Error processing import: %v\n", err)
 		}
+	}
+
+	if engine.AI() && options.aiSync {
+		if err := engine.ProcessEmbeddings(ctx); err != nil {
+			log.Fatalf("Error processing embeddings: %v\n", err)
+		}
+	} else if options.aiAnn {
+		if err := engine.ProcessANN(ctx); err != nil {
+			log.Fatalf("Error processing ANN index: %v\n", err)
+		}
+	}
 
-		if options.dbCompress {
-			if err := db.Compress(); err != nil {
-				log.Fatalf("Error compressing the database: %v\n", err)
+	if options.dbTokenizer != "" {
+		for _, e := range engines {
+			if err := e.Tokenize(ctx, options.dbTokenizer); err != nil {
+				log.Fatalf("Error rebuilding the full text indexes of %s: %v\n", e.ID, err)
 			}
 		}
+	}
+
+	if options.dbVocabulary {
+		if err := engine.ProcessVocabulary(ctx); err != nil {
+			log.Fatalf("Error processing vocabulary: %v\n", err)
+		}
+	}
+
+	if options.dbVocabularyVectors > 0 {
+		if err := engine.ProcessVocabularyVectors(ctx, options.dbVocabularyVectors); err != nil {
+			log.Fatalf("Error processing vocabulary vectors: %v\n", err)
+		}
+	}
+
+	if options.dbRelated {
+		if err := engine.ProcessRelated(ctx); err != nil {
+			log.Fatalf("Error processing related articles: %v\n", err)
+		}
+	}
+
+	if options.dbSuggest {
+		if err := engine.ProcessSuggest(ctx); err != nil {
+			log.Fatalf("Error processing suggestions: %v\n", err)
+		}
+	}
+
+	if options.aiVectorsDrop {
+		if err := engine.VectorsDrop(ctx); err != nil {
+			log.Fatalf("Error dropping the vectors: %v\n", err)
+		}
+	}
+
+	if options.dbCompress {
+		if err := engine.Compress(ctx); err != nil {
+			log.Fatalf("Error compressing the database: %v\n", err)
+		}
+	}
 
-		if err := db.PragmaReadMode(); err != nil {
-			log.Fatalf("Error setting database in read mode: %v\n", err)
+	if options.dbStats {
+		for _, e := range engines {
+			stats, err := e.Stats(ctx)
+			if err != nil {
+				log.Fatalf("Error reading database statistics: %v\n", err)
+			}
+			if len(engines) > 1 {
+				fmt.Printf("Database: %s\n", e.ID)
+			}
+			fmt.Println(stats)
 		}
 	}
 
@@ -201,3 +350,103 @@ func main() {
 	}
 
 }
+
+func dbPathsExpand(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return []string{"wikilite.db"}, nil
+	}
+
+	var expanded []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			matches, err := filepath.Glob(filepath.Join(path, "*.db"))
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no database found in directory %s", path)
+			}
+			expanded = append(expanded, matches...)
+		} else {
+			expanded = append(expanded, path)
+		}
+	}
+
+	return expanded, nil
+}
+
+func engineConfig(dbPath string) wikilite.Config {
+	return wikilite.Config{
+		Path:                  dbPath,
+		Language:              options.language,
+		AiAnn:                 options.aiAnn,
+		AiAnnMode:             options.aiAnnMode,
+		AiAnnSize:             options.aiAnnSize,
+		AiAnnM:                options.aiAnnM,
+		AiAnnEfConstruction:   options.aiAnnEfConstruction,
+		AiAnnEfSearch:         options.aiAnnEfSearch,
+		AiAnnLists:            options.aiAnnLists,
+		AiAnnProbe:            options.aiAnnProbe,
+		AiAnnSubvectors:       options.aiAnnSubvectors,
+		AiApi:                 options.aiApi,
+		AiApiKey:              options.aiApiKey,
+		AiApiUrl:              options.aiApiUrl,
+		AiModel:               options.aiModel,
+		AiModelPrefixSave:     options.aiModelPrefixSave,
+		AiModelPrefixSearch:   options.aiModelPrefixSearch,
+		AiRerankApiUrl:        options.aiRerankApiUrl,
+		AiRerankModel:         options.aiRerankModel,
+		AiChatApiUrl:          options.aiChatApiUrl,
+		AiChatModel:           options.aiChatModel,
+		AiThreads:             options.aiThreads,
+		Tokenizer:             options.dbTokenizer,
+		SearchRerank:          options.searchRerank,
+		SearchRrfK:            options.searchRrfK,
+		SearchTimeout:         options.searchTimeout,
+		CacheSize:             options.cacheSize,
+		SearchWeightTitle:     options.searchWeightTitle,
+		SearchWeightContent:   options.searchWeightContent,
+		SearchWeightSemantic:  options.searchWeightSemantic,
+		SearchWeightExpansion: options.searchWeightExpansion,
+	}
+}
+
+func engineAppend(e *wikilite.Engine) {
+	id := e.ID
+	for n := 2; engineGet(e.ID) != nil; n++ {
+		e.ID = fmt.Sprintf("%s-%d", id, n)
+	}
+	engines = append(engines, e)
+}
+
+func engineGet(id string) *wikilite.Engine {
+	if id == "" {
+		return engine
+	}
+	for _, e := range engines {
+		if e.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// engineChat returns the first database with a chat model, nil when none has
+// one.
+func engineChat() *wikilite.Engine {
+	for _, e := range engines {
+		if e.Chat() {
+			return e
+		}
+	}
+	return nil
+}
+
+func engineAI() bool {
+	for _, e := range engines {
+		if e.AI() {
+			return true
+		}
+	}
+	return false
+}
@@ -4,88 +4,298 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"wikilite/wikilite"
 )
 
-func Search(query string, limit int) ([]SearchResult, error) {
-	var results []SearchResult
+// searchFacets is the number of values returned for every facet.
+const searchFacets = 10
+
+// answerLimit is the default number of sentences answering a question.
+const answerLimit = 1
+
+// askSources is the number of sections the chat model reads to answer.
+const askSources = 3
+
+// searchLimitMax is the number of results an API request can ask for at most.
+const searchLimitMax = 100
 
-	lexical, err := SearchLexical(query, limit)
+// searchDepthMax is the deepest ranking a search cursor can ask for.
+const searchDepthMax = 1000
+
+func Search(ctx context.Context, query string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error) {
+	return searchFederated(ctx, query, options, (*wikilite.Engine).Search)
+}
+
+func SearchSemantic(ctx context.Context, query string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error) {
+	return searchFederated(ctx, query, options, (*wikilite.Engine).SearchSemantic)
+}
+
+func SearchLexical(ctx context.Context, query string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error) {
+	return searchFederated(ctx, query, options, (*wikilite.Engine).SearchLexical)
+}
+
+func SearchTitle(ctx context.Context, query string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error) {
+	return searchFederated(ctx, query, options, (*wikilite.Engine).SearchTitle)
+}
+
+func SearchWordDistance(ctx context.Context, word string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error) {
+	var results []wikilite.SearchResult
+	seen := make(map[string]bool)
+	offset := options.Offset
+	limit := options.Offset + options.Limit
+
+	options.Offset = 0
+	options.Limit = limit * len(engines)
+	matches, err := searchFederated(ctx, word, options, func(e *wikilite.Engine, ctx context.Context, word string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error) {
+		return e.SearchWordDistance(ctx, word, options.Limit)
+	})
 	if err != nil {
 		return nil, err
 	}
-	results = append(results, lexical...)
 
-	semantic, err := SearchSemantic(query, limit)
-	if err != nil {
-		return nil, err
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Power < matches[j].Power
+	})
+	for _, match := range matches {
+		if !seen[match.Text] && len(results) < limit {
+			seen[match.Text] = true
+			match.DB = ""
+			results = append(results, match)
+		}
+	}
+	if offset >= len(results) {
+		return nil, nil
 	}
-	results = append(results, semantic...)
 
-	return searchOptimize(results, limit), nil
+	return results[offset:], nil
 }
 
-func SearchSemantic(query string, limit int) ([]SearchResult, error) {
-	var results []SearchResult
+// Suggest completes a title prefix across the loaded databases.
+func Suggest(ctx context.Context, prefix string, limit int) ([]wikilite.SearchResult, error) {
+	return searchFederated(ctx, prefix, wikilite.SearchOptions{Limit: limit}, func(e *wikilite.Engine, ctx context.Context, prefix string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error) {
+		return e.Suggest(ctx, prefix, options.Limit)
+	})
+}
 
-	if ai {
-		vectors, err := db.SearchVectors(query, limit)
+// SearchCount sums the lexical matches of every loaded database, stopping at
+// limit when it is above 0.
+func SearchCount(ctx context.Context, query string, filter wikilite.SearchFilter, limit int) (int, error) {
+	total := 0
+	for _, e := range engines {
+		count, err := e.SearchCount(ctx, query, filter, limit-total)
 		if err != nil {
-			return nil, err
+			return 0, fmt.Errorf("%s: %v", e.ID, err)
+		}
+		total += count
+		if limit > 0 && total >= limit {
+			break
 		}
-		for _, vector := range vectors {
-			results = append(results, vector)
+	}
+	return total, nil
+}
+
+// SearchFacets sums the facet counts of every loaded database, keeping the
+// limit most frequent values of each facet.
+func SearchFacets(ctx context.Context, query string, filter wikilite.SearchFilter, limit int) (map[string][]wikilite.SearchFacet, error) {
+	counts := make(map[string]map[string]int)
+	for _, e := range engines {
+		facets, err := e.SearchFacets(ctx, query, filter, limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.ID, err)
+		}
+		for name, values := range facets {
+			if counts[name] == nil {
+				counts[name] = make(map[string]int)
+			}
+			for _, value := range values {
+				counts[name][value.Value] += value.Count
+			}
 		}
 	}
 
-	return results, nil
+	facets := make(map[string][]wikilite.SearchFacet)
+	for name, values := range counts {
+		for value, count := range values {
+			facets[name] = append(facets[name], wikilite.SearchFacet{Value: value, Count: count})
+		}
+		sort.Slice(facets[name], func(i, j int) bool {
+			if facets[name][i].Count != facets[name][j].Count {
+				return facets[name][i].Count > facets[name][j].Count
+			}
+			return facets[name][i].Value < facets[name][j].Value
+		})
+		if len(facets[name]) > limit {
+			facets[name] = facets[name][:limit]
+		}
+	}
+	return facets, nil
 }
 
-func SearchLexical(query string, limit int) ([]SearchResult, error) {
-	var results []SearchResult
-	var err error
+// Answer returns the limit sentences answering question best among the
+// loaded databases.
+func Answer(ctx context.Context, question string, limit int) ([]wikilite.AnswerResult, error) {
+	var answers []wikilite.AnswerResult
+	for _, e := range engines {
+		engineAnswers, err := e.Answer(ctx, question, limit)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.ID, err)
+		}
+		answers = append(answers, engineAnswers...)
+	}
 
-	results, err = SearchTitle(query, limit)
-	if err != nil {
-		return nil, err
+	sort.SliceStable(answers, func(i, j int) bool {
+		return answers[i].Score > answers[j].Score
+	})
+	if len(answers) > limit {
+		answers = answers[:limit]
 	}
+	return answers, nil
+}
 
-	contents, err := db.SearchContent(query, limit)
-	if err != nil {
-		return nil, err
+// AskSources returns the askSources sections found for question among the
+// loaded databases, renumbered in the order of their score.
+func AskSources(ctx context.Context, question string) ([]wikilite.AskSource, error) {
+	var sources []wikilite.AskSource
+	for _, e := range engines {
+		engineSources, err := e.AskSources(ctx, question, askSources)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", e.ID, err)
+		}
+		sources = append(sources, engineSources...)
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Score > sources[j].Score
+	})
+	if len(sources) > askSources {
+		sources = sources[:askSources]
 	}
-	for _, content := range contents {
-		results = append(results, content)
+	for i := range sources {
+		sources[i].Number = i + 1
 	}
+	return sources, nil
+}
 
-	return searchOptimize(results, limit), nil
+// SearchCorrect returns the first spelling correction of query offered by the
+// loaded databases.
+func SearchCorrect(ctx context.Context, query string) (string, error) {
+	for _, e := range engines {
+		corrected, err := e.SearchCorrect(ctx, query)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", e.ID, err)
+		}
+		if corrected != "" {
+			return corrected, nil
+		}
+	}
+	return "", nil
 }
 
-func SearchTitle(query string, limit int) ([]SearchResult, error) {
-	var results []SearchResult
+// searchFederated runs searchFunc on every loaded database at once and
+// interleaves the per database rankings, ordered by the normalized Score
+// when the search provides one. Every database returns its first
+// Offset+Limit results, out of a ranking as deep as that of a single
+// database, so that the page can be cut from the merged ranking.
+// When a database returns partial results they are merged as well and
+// wikilite.ErrSearchPartial is returned along with them.
+func searchFederated(ctx context.Context, query string, options wikilite.SearchOptions, searchFunc func(e *wikilite.Engine, ctx context.Context, query string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error)) ([]wikilite.SearchResult, error) {
+	var wg sync.WaitGroup
+	dbResults := make([][]wikilite.SearchResult, len(engines))
+	dbErrors := make([]error, len(engines))
+	partial := false
 
-	titles, err := db.SearchTitle(query, limit)
-	if err != nil {
-		return nil, err
+	engineOptions := options
+	if len(engines) > 1 {
+		engineOptions.Depth = wikilite.SearchDepth(options)
+		engineOptions.Offset = 0
+		engineOptions.Limit = options.Offset + options.Limit
 	}
-	for _, title := range titles {
-		results = append(results, title)
+
+	for i, e := range engines {
+		wg.Add(1)
+		go func(i int, e *wikilite.Engine) {
+			defer wg.Done()
+			results, err := searchFunc(e, ctx, query, engineOptions)
+			if errors.Is(err, wikilite.ErrSearchPartial) {
+				dbErrors[i] = err
+			} else if err != nil {
+				dbErrors[i] = fmt.Errorf("%s: %v", e.ID, err)
+				return
+			}
+			for j := range results {
+				results[j].DB = e.ID
+			}
+			dbResults[i] = results
+		}(i, e)
 	}
+	wg.Wait()
 
-	return results, nil
-}
+	for _, err := range dbErrors {
+		if errors.Is(err, wikilite.ErrSearchPartial) {
+			partial = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if partial {
+		err = wikilite.ErrSearchPartial
+	}
+
+	if len(dbResults) == 1 {
+		return dbResults[0], err
+	}
 
-func SearchWordDistance(word string, limit int) ([]SearchResult, error) {
-	return db.SearchWordDistance(word, limit)
+	var results []wikilite.SearchResult
+	for rank := 0; ; rank++ {
+		found := false
+		for _, dbResult := range dbResults {
+			if rank < len(dbResult) {
+				results = append(results, dbResult[rank])
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if options.Offset >= len(results) {
+		return nil, err
+	}
+	results = results[options.Offset:]
+	if len(results) > options.Limit {
+		results = results[:options.Limit]
+	}
+
+	return results, err
 }
 
+// SearchCli reads queries from the standard input, a result number opens the
+// article while + and - move to the next and previous page of results. A
+// query starting with ? is a question, answered with the sentences of the
+// top results that best match it.
 func SearchCli() error {
 	reader := bufio.NewReader(os.Stdin)
-	articles := make(map[int]int)
+	ctx := context.Background()
+	articles := make(map[int]wikilite.SearchResult)
+	lastQuery := ""
+	offset := 0
 
 	for {
 		fmt.Print("> ")
@@ -95,10 +305,32 @@ func SearchCli() error {
 			return nil
 		}
 
+		if question, found := strings.CutPrefix(query, "?"); found {
+			answers, err := Answer(ctx, strings.TrimSpace(question), answerLimit)
+			if err != nil {
+				log.Fatal("CLI error: ", err)
+			}
+			if len(answers) == 0 {
+				fmt.Println("No answer found")
+			}
+			for _, answer := range answers {
+				fmt.Println(answer.Text)
+				citation := answer.ArticleTitle
+				if answer.SectionTitle != "" {
+					citation += " > " + answer.SectionTitle
+				}
+				if len(engines) > 1 {
+					citation += " (" + answer.DB + ")"
+				}
+				fmt.Printf("  [%s]\n", citation)
+			}
+			continue
+		}
+
 		queryIdx, err := strconv.Atoi(query)
 		if err == nil {
-			if articleID, exists := articles[queryIdx]; exists {
-				article, err := db.ArticleGet(articleID)
+			if result, exists := articles[queryIdx]; exists {
+				article, err := ArticleGet(ctx, result.DB, result.ArticleID)
 				if err != nil {
 					log.Fatal("CLI error: ", err)
 				}
@@ -114,41 +346,123 @@ func SearchCli() error {
 			}
 		}
 
+		if lastQuery != "" && (query == "+" || query == "-") {
+			if query == "+" {
+				offset += options.limit
+			} else {
+				offset = max(0, offset-options.limit)
+			}
+			query = lastQuery
+		} else if query != "" {
+			offset = 0
+		}
+
 		if query != "" {
-			results, err := Search(query, options.limit)
-			if err != nil {
+			search := searchOptions(options.limit + 1)
+			search.Offset = offset
+			if options.searchExplain {
+				search.Explain = &wikilite.SearchTrace{}
+			}
+			results, err := Search(ctx, query, search)
+			if errors.Is(err, wikilite.ErrSearchPartial) {
+				fmt.Println("Search timeout, partial results")
+			} else if err != nil {
 				log.Fatal("CLI error: ", err)
 			}
+			lastQuery = query
+
+			more := len(results) > options.limit
+			if more {
+				results = results[:options.limit]
+			}
 
-			articles = make(map[int]int)
+			articles = make(map[int]wikilite.SearchResult)
 			for i, result := range results {
-				articles[i+1] = result.ArticleID
-				fmt.Printf("% 3d [%s] %s\n", i+1, result.Type, result.Title)
+				articles[offset+i+1] = result
+				if len(engines) > 1 {
+					fmt.Printf("% 3d [%s] %s (%s)\n", offset+i+1, result.Type, result.Title, result.DB)
+				} else {
+					fmt.Printf("% 3d [%s] %s\n", offset+i+1, result.Type, result.Title)
+				}
+				if search.Explain != nil {
+					searchExplainPrint(result)
+				}
+			}
+			if search.Explain != nil {
+				for _, stage := range search.Explain.Stages {
+					fmt.Printf("    %s %s: %v\n", stage.DB, stage.Name, time.Duration(stage.Time*float64(time.Second)))
+				}
+			}
+			if total, err := SearchCount(ctx, query, wikilite.SearchFilter{}, 1); err == nil && total == 0 && offset == 0 {
+				if corrected, err := SearchCorrect(ctx, query); err == nil && corrected != "" {
+					fmt.Printf("Did you mean: %s\n", corrected)
+				}
+			}
+			if more {
+				fmt.Println("  + next page")
+			}
+			if offset > 0 {
+				fmt.Println("  - previous page")
 			}
 		}
 	}
 }
 
-func searchOptimize(results []SearchResult, limit int) []SearchResult {
-	seen := make(map[int]bool)
-	accumulatedResults := []SearchResult{}
+// searchExplainPrint shows how every retriever ranked a result.
+func searchExplainPrint(result wikilite.SearchResult) {
+	names := make([]string, 0, len(result.Retrievers))
+	for name := range result.Retrievers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	for _, result := range results {
-		if !seen[result.ArticleID] {
-			seen[result.ArticleID] = true
-			accumulatedResults = append(accumulatedResults, result)
-		} else {
-			for i := range accumulatedResults {
-				if accumulatedResults[i].ArticleID == result.ArticleID {
-					accumulatedResults[i].Power += result.Power
-					break
-				}
+	for _, name := range names {
+		retriever := result.Retrievers[name]
+		line := fmt.Sprintf("      %s: rank %d, power %.4f, score %.4f", name, retriever.Rank, retriever.Power, retriever.Score)
+		if explain := retriever.Explain; explain != nil {
+			line += " (" + explain.Table
+			if explain.SectionID > 0 {
+				line += fmt.Sprintf(", section %d", explain.SectionID)
 			}
+			if explain.Ann != nil {
+				line += fmt.Sprintf(", ann rank %d, distance %.4f, chunk %d, position %d", explain.Ann.Rank, explain.Ann.Distance, explain.Ann.Chunk, explain.Ann.Position)
+			}
+			line += ")"
 		}
-		if len(accumulatedResults) >= limit {
-			break
-		}
+		fmt.Println(line)
 	}
+}
+
+func searchOptions(limit int) wikilite.SearchOptions {
+	return wikilite.SearchOptions{
+		Limit:   limit,
+		Snippet: options.searchSnippet,
+		Expand:  options.searchExpand,
+	}
+}
 
-	return accumulatedResults
+// searchCursorEncode returns an opaque cursor pointing at offset in the
+// results of query, ranked depth deep.
+func searchCursorEncode(query string, offset int, depth int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%s", offset, depth, calculateHash([]string{query})[:8])))
+}
+
+func searchCursorDecode(query string, cursor string) (offset int, depth int, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 3 || parts[2] != calculateHash([]string{query})[:8] {
+		return 0, 0, fmt.Errorf("invalid cursor")
+	}
+	offset, err = strconv.Atoi(parts[0])
+	if err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("invalid cursor")
+	}
+	depth, err = strconv.Atoi(parts[1])
+	if err != nil || depth <= 0 || depth > searchDepthMax {
+		return 0, 0, fmt.Errorf("invalid cursor")
+	}
+	return offset, depth, nil
 }
@@ -160,8 +160,6 @@ func SetupDownloadAndExtract(selectedGroup []SetupSibling, progressDbCallback fu
 		})
 		if err != nil {
 			return fmt.Errorf("error downloading and extracting file %s: %v", part.Rfilename, err)
-		} else {
-			db, _ = NewDBHandler(options.dbPath)
 		}
 	}
 
@@ -3,29 +3,65 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"wikilite/wikilite"
 )
 
 type APIRequest struct {
-	Query string `json:"query,omitempty"`
-	Limit int    `json:"limit,omitempty"`
-	ID    int    `json:"id,omitempty"`
+	Query    string `json:"query,omitempty"`
+	Question string `json:"question,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	Offset   int    `json:"offset,omitempty"`
+	Cursor   string `json:"cursor,omitempty"`
+	Snippet  int    `json:"snippet,omitempty"`
+	ID       int    `json:"id,omitempty"`
+	DB       string `json:"db,omitempty"`
+	Entity   string `json:"entity,omitempty"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Prefix   string `json:"prefix,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Facets   bool   `json:"facets,omitempty"`
+	Count    bool   `json:"count,omitempty"`
+	Expand   *bool  `json:"expand,omitempty"`
+	Explain  bool   `json:"explain,omitempty"`
+
+	Filter wikilite.SearchFilter `json:"filter,omitempty"`
 }
 
 type APIResponse struct {
-	Status  string          `json:"status"`
-	Message string          `json:"message,omitempty"`
-	Results *[]SearchResult `json:"results,omitempty"`
-	Article *ArticleResult  `json:"article,omitempty"`
-	Time    float64         `json:"time"`
+	Status  string                            `json:"status"`
+	Message string                            `json:"message,omitempty"`
+	Results *[]wikilite.SearchResult          `json:"results,omitempty"`
+	Answers *[]wikilite.AnswerResult          `json:"answers,omitempty"`
+	Sources *[]wikilite.AskSource             `json:"sources,omitempty"`
+	Text    string                            `json:"text,omitempty"`
+	Total   *int                              `json:"total,omitempty"`
+	Offset  *int                              `json:"offset,omitempty"`
+	HasMore *bool                             `json:"has_more,omitempty"`
+	Partial bool                              `json:"partial,omitempty"`
+	Explain *wikilite.SearchTrace             `json:"explain,omitempty"`
+	Cursor  string                            `json:"next_cursor,omitempty"`
+	Suggest string                            `json:"suggestion,omitempty"`
+	Facets  map[string][]wikilite.SearchFacet `json:"facets,omitempty"`
+	Article *wikilite.ArticleResult           `json:"article,omitempty"`
+	Section *wikilite.SectionResult           `json:"section,omitempty"`
+	Stats   *wikilite.DBStats                 `json:"stats,omitempty"`
+	Time    float64                           `json:"time"`
 }
 
 type WebServer struct {
@@ -33,7 +69,11 @@ type WebServer struct {
 }
 
 func NewWebServer() (*WebServer, error) {
-	tmpl, err := template.ParseFS(assets, "assets/templates/*")
+	tmpl, err := template.New(Name).Funcs(template.FuncMap{
+		"snippet": func(snippet string) template.HTML {
+			return template.HTML(snippet)
+		},
+	}).ParseFS(assets, "assets/templates/*")
 	if err != nil {
 		return nil, fmt.Errorf("error parsing templates: %v", err)
 	}
@@ -54,63 +94,139 @@ func (s *WebServer) executeTemplate(w http.ResponseWriter, templateName string,
 func (s *WebServer) handleHTMLSearch(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var query string
-	var limit int
-	var results []SearchResult
+	var limit, offset, total, depth int
+	var suggestion string
+	var partial bool
+	var results []wikilite.SearchResult
+	var facets map[string][]wikilite.SearchFacet
 
-	if r.Method == "POST" {
-		query = r.FormValue("query")
-		limit, _ = strconv.Atoi(r.FormValue("limit"))
-	}
+	query = r.FormValue("query")
+	limit, _ = strconv.Atoi(r.FormValue("limit"))
+	offset, _ = strconv.Atoi(r.FormValue("offset"))
 
 	if limit <= 0 {
 		limit = options.limit
 	}
+	limit = min(limit, searchLimitMax)
+	if offset < 0 {
+		offset = 0
+	}
+
+	filter, err := searchFilterParse(r.Form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if query != "" {
-		results, err = Search(query, limit)
+		search := searchOptions(limit + 1)
+		search.Offset = offset
+		search.Filter = filter
+		depth = wikilite.SearchDepth(search)
+		results, err = Search(r.Context(), query, search)
+		if errors.Is(err, wikilite.ErrSearchPartial) {
+			partial, err = true, nil
+		}
+		if err == nil {
+			total, err = SearchCount(r.Context(), query, filter, depth+1)
+		}
+		if err == nil {
+			facets, err = SearchFacets(r.Context(), query, filter, searchFacets)
+		}
+		if err == nil && total == 0 {
+			suggestion, err = SearchCorrect(r.Context(), query)
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	}
 
+	// Pages end with the fused ranking, so the count and the next page
+	// do not promise results past its depth.
+	results = results[:min(len(results), max(0, depth-offset))]
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+	}
+	totalMore := total > depth
+	total = min(total, depth)
+
 	s.executeTemplate(w, "search.html", struct {
-		Query    string
-		Limit    int
-		Results  []SearchResult
-		HasQuery bool
-		Language string
-		AI       bool
+		Query      string
+		Limit      int
+		Offset     int
+		Total      int
+		TotalMore  bool
+		Previous   int
+		Next       int
+		HasMore    bool
+		Suggestion string
+		Partial    bool
+		Section    string
+		Facets     []wikilite.SearchFacet
+		Results    []wikilite.SearchResult
+		HasQuery   bool
+		Language   string
+		AI         bool
+		Federated  bool
 	}{
-		Query:    query,
-		Limit:    limit,
-		Results:  results,
-		HasQuery: query != "",
-		Language: options.language,
-		AI:       ai,
+		Query:      query,
+		Limit:      limit,
+		Offset:     offset,
+		Total:      total,
+		TotalMore:  totalMore,
+		Previous:   max(0, offset-limit),
+		Next:       offset + limit,
+		HasMore:    hasMore,
+		Suggestion: suggestion,
+		Partial:    partial,
+		Section:    r.FormValue("section"),
+		Facets:     facets["section"],
+		Results:    results,
+		HasQuery:   query != "",
+		Language:   engine.Config().Language,
+		AI:         engineAI(),
+		Federated:  len(engines) > 1,
 	})
 }
 
 func (s *WebServer) handleHTMLArticle(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		value := r.FormValue("id")
-		id, err := strconv.Atoi(value)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+		var result wikilite.ArticleResult
+		var err error
+
+		if entity := r.FormValue("entity"); entity != "" {
+			result, err = ArticleGetByEntity(r.Context(), entity, r.FormValue("language"))
+		} else if title := r.FormValue("title"); title != "" {
+			result, err = ArticleGetByTitle(r.Context(), r.FormValue("db"), title)
+		} else {
+			var id int
+			id, err = strconv.Atoi(r.FormValue("id"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result, err = ArticleGet(r.Context(), r.FormValue("db"), id)
 		}
-		result, err := db.ArticleGet(id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		related, err := ArticleRelated(r.Context(), result.DB, result.ID, relatedLimit)
+		if err != nil {
+			log.Printf("Error retrieving related articles: %v", err)
+		}
+
 		s.executeTemplate(w, "article.html", struct {
 			Language string
-			Result   ArticleResult
+			Result   wikilite.ArticleResult
+			Related  []wikilite.SearchResult
 		}{
-			Language: options.language,
+			Language: result.Language,
 			Result:   result,
+			Related:  related,
 		})
 	}
 }
@@ -124,12 +240,28 @@ func (s *WebServer) sendAPIError(w http.ResponseWriter, message string, statusCo
 	})
 }
 
-func (s *WebServer) handleGenericAPISearch(w http.ResponseWriter, r *http.Request, searchFunc func(query string, limit int) ([]SearchResult, error)) {
+// sendAPIEvent writes response as a server sent event and flushes it to the
+// client right away.
+func (s *WebServer) sendAPIEvent(w http.ResponseWriter, event string, response APIResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (s *WebServer) handleGenericAPISearch(w http.ResponseWriter, r *http.Request, searchFunc func(ctx context.Context, query string, options wikilite.SearchOptions) ([]wikilite.SearchResult, error), countFunc func(ctx context.Context, query string, filter wikilite.SearchFilter, limit int) (int, error)) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var request APIRequest
 	var query string
-	var limit int = options.limit
+	var search = searchOptions(options.limit)
 	var err error
 
 	startTime := time.Now()
@@ -141,17 +273,64 @@ func (s *WebServer) handleGenericAPISearch(w http.ResponseWriter, r *http.Reques
 		}
 		query = request.Query
 		if request.Limit > 0 {
-			limit = request.Limit
+			search.Limit = request.Limit
+		}
+		if request.Snippet != 0 {
+			search.Snippet = request.Snippet
+		}
+		search.Offset = request.Offset
+		search.Filter = request.Filter
+		if request.Expand != nil {
+			search.Expand = *request.Expand
+		}
+		if request.Explain {
+			search.Explain = &wikilite.SearchTrace{}
 		}
 	} else {
+		request.Cursor = r.URL.Query().Get("cursor")
+		request.Facets, _ = strconv.ParseBool(r.URL.Query().Get("facets"))
+		request.Count, _ = strconv.ParseBool(r.URL.Query().Get("count"))
+		if search.Filter, err = searchFilterParse(r.URL.Query()); err != nil {
+			s.sendAPIError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		query = r.URL.Query().Get("query")
 		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
-			limit, err = strconv.Atoi(limitStr)
+			search.Limit, err = strconv.Atoi(limitStr)
 			if err != nil {
 				s.sendAPIError(w, "Invalid limit parameter", http.StatusBadRequest)
 				return
 			}
 		}
+		if snippetStr := r.URL.Query().Get("snippet"); snippetStr != "" {
+			search.Snippet, err = strconv.Atoi(snippetStr)
+			if err != nil {
+				s.sendAPIError(w, "Invalid snippet parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+			search.Offset, err = strconv.Atoi(offsetStr)
+			if err != nil {
+				s.sendAPIError(w, "Invalid offset parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if expandStr := r.URL.Query().Get("expand"); expandStr != "" {
+			search.Expand, err = strconv.ParseBool(expandStr)
+			if err != nil {
+				s.sendAPIError(w, "Invalid expand parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if explainStr := r.URL.Query().Get("explain"); explainStr != "" {
+			if explain, err := strconv.ParseBool(explainStr); err != nil {
+				s.sendAPIError(w, "Invalid explain parameter", http.StatusBadRequest)
+				return
+			} else if explain {
+				search.Explain = &wikilite.SearchTrace{}
+			}
+		}
 	}
 	log.Printf("API %s search: %s", r.Method, query)
 
@@ -160,48 +339,141 @@ func (s *WebServer) handleGenericAPISearch(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	results, err := searchFunc(query, limit)
-	if err != nil {
+	if request.Cursor != "" {
+		if search.Offset, search.Depth, err = searchCursorDecode(query, request.Cursor); err != nil {
+			s.sendAPIError(w, "Invalid cursor parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if search.Limit <= 0 || search.Limit > searchLimitMax || search.Offset < 0 {
+		s.sendAPIError(w, "Invalid limit or offset parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := search.Limit
+	search.Depth = wikilite.SearchDepth(search)
+	search.Limit++
+	results, err := searchFunc(r.Context(), query, search)
+	partial := errors.Is(err, wikilite.ErrSearchPartial)
+	if err != nil && !partial {
 		s.sendAPIError(w, fmt.Sprintf("Search error: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(APIResponse{
+	response := APIResponse{
 		Status:  "success",
-		Results: &results,
-		Time:    time.Since(startTime).Seconds(),
-	})
+		Offset:  &search.Offset,
+		Partial: partial,
+		Explain: search.Explain,
+	}
+
+	hasMore := len(results) > limit
+	if hasMore {
+		results = results[:limit]
+		response.Cursor = searchCursorEncode(query, search.Offset+limit, search.Depth)
+	}
+	response.Results = &results
+	response.HasMore = &hasMore
+
+	if countFunc != nil {
+		countLimit := 1
+		if request.Count {
+			countLimit = 0
+		}
+		total, err := countFunc(r.Context(), query, search.Filter, countLimit)
+		if err != nil {
+			s.sendAPIError(w, fmt.Sprintf("Search error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if request.Count {
+			response.Total = &total
+		}
+
+		if total == 0 {
+			if response.Suggest, err = SearchCorrect(r.Context(), query); err != nil {
+				s.sendAPIError(w, fmt.Sprintf("Search error: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if request.Facets {
+			if response.Facets, err = SearchFacets(r.Context(), query, search.Filter, searchFacets); err != nil {
+				s.sendAPIError(w, fmt.Sprintf("Search error: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	response.Time = time.Since(startTime).Seconds()
+	json.NewEncoder(w).Encode(response)
+
+}
+
+// searchFilterParse reads a search filter from request parameters: comma
+// separated retrievers and article_ids, one section parameter per heading,
+// level_min and level_max.
+func searchFilterParse(values url.Values) (wikilite.SearchFilter, error) {
+	var filter wikilite.SearchFilter
+	var err error
+
+	if retrievers := values.Get("retrievers"); retrievers != "" {
+		filter.Retrievers = strings.Split(retrievers, ",")
+	}
+	if articleIDs := values.Get("article_ids"); articleIDs != "" {
+		for _, idStr := range strings.Split(articleIDs, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(idStr))
+			if err != nil {
+				return filter, fmt.Errorf("Invalid article_ids parameter")
+			}
+			filter.ArticleIDs = append(filter.ArticleIDs, id)
+		}
+	}
+	for _, section := range values["section"] {
+		if section != "" {
+			filter.Sections = append(filter.Sections, section)
+		}
+	}
+	if levelStr := values.Get("level_min"); levelStr != "" {
+		if filter.LevelMin, err = strconv.Atoi(levelStr); err != nil {
+			return filter, fmt.Errorf("Invalid level_min parameter")
+		}
+	}
+	if levelStr := values.Get("level_max"); levelStr != "" {
+		if filter.LevelMax, err = strconv.Atoi(levelStr); err != nil {
+			return filter, fmt.Errorf("Invalid level_max parameter")
+		}
+	}
 
+	return filter, nil
 }
 
 func (s *WebServer) handleAPISearch(w http.ResponseWriter, r *http.Request) {
-	s.handleGenericAPISearch(w, r, Search)
+	s.handleGenericAPISearch(w, r, Search, SearchCount)
 }
 
 func (s *WebServer) handleAPISearchTitle(w http.ResponseWriter, r *http.Request) {
-	s.handleGenericAPISearch(w, r, SearchTitle)
+	s.handleGenericAPISearch(w, r, SearchTitle, nil)
 }
 
 func (s *WebServer) handleAPISearchLexical(w http.ResponseWriter, r *http.Request) {
-	s.handleGenericAPISearch(w, r, SearchLexical)
+	s.handleGenericAPISearch(w, r, SearchLexical, SearchCount)
 }
 
 func (s *WebServer) handleAPISearchWordDistance(w http.ResponseWriter, r *http.Request) {
-	s.handleGenericAPISearch(w, r, SearchWordDistance)
+	s.handleGenericAPISearch(w, r, SearchWordDistance, nil)
 }
 
 func (s *WebServer) handleAPISearchSemantic(w http.ResponseWriter, r *http.Request) {
-	if !ai {
+	if !engineAI() {
 		s.sendAPIError(w, "Semantic search is not enabled", http.StatusBadRequest)
 		return
 	}
-	s.handleGenericAPISearch(w, r, SearchSemantic)
+	s.handleGenericAPISearch(w, r, SearchSemantic, nil)
 }
 
 func (s *WebServer) handleAPIArticle(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var request APIRequest
-	var id int
 	var err error
 
 	startTime := time.Now()
@@ -211,22 +483,144 @@ func (s *WebServer) handleAPIArticle(w http.ResponseWriter, r *http.Request) {
 			s.sendAPIError(w, "Invalid JSON request", http.StatusBadRequest)
 			return
 		}
-		id = request.ID
 	} else {
+		request.DB = r.URL.Query().Get("db")
+		request.Entity = r.URL.Query().Get("entity")
+		request.Language = r.URL.Query().Get("language")
+		request.Title = r.URL.Query().Get("title")
+		if request.Entity == "" && request.Title == "" {
+			idStr := r.URL.Query().Get("id")
+			if idStr == "" {
+				s.sendAPIError(w, "ID parameter is required", http.StatusBadRequest)
+				return
+			}
+			request.ID, err = strconv.Atoi(idStr)
+			if err != nil {
+				s.sendAPIError(w, "Invalid ID parameter", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var article wikilite.ArticleResult
+	if request.Entity != "" {
+		log.Printf("API %s article: %s %s", r.Method, request.Entity, request.Language)
+		article, err = ArticleGetByEntity(r.Context(), request.Entity, request.Language)
+	} else if request.Title != "" {
+		log.Printf("API %s article: %s %s", r.Method, request.Title, request.DB)
+		article, err = ArticleGetByTitle(r.Context(), request.DB, request.Title)
+	} else {
+		log.Printf("API %s article: %d %s", r.Method, request.ID, request.DB)
+		article, err = ArticleGet(r.Context(), request.DB, request.ID)
+	}
+	if err != nil {
+		s.sendAPIError(w, fmt.Sprintf("Error retrieving article: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Status:  "success",
+		Article: &article,
+		Time:    time.Since(startTime).Seconds(),
+	})
+}
+
+// handleAPIArticleRelated lists the articles most similar to the given one.
+func (s *WebServer) handleAPIArticleRelated(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var request APIRequest
+	var err error
+
+	startTime := time.Now()
+
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			s.sendAPIError(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+	} else {
+		request.DB = r.URL.Query().Get("db")
 		idStr := r.URL.Query().Get("id")
 		if idStr == "" {
 			s.sendAPIError(w, "ID parameter is required", http.StatusBadRequest)
 			return
 		}
-		id, err = strconv.Atoi(idStr)
+		request.ID, err = strconv.Atoi(idStr)
 		if err != nil {
 			s.sendAPIError(w, "Invalid ID parameter", http.StatusBadRequest)
 			return
 		}
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if request.Limit, err = strconv.Atoi(limitStr); err != nil || request.Limit <= 0 || request.Limit > searchLimitMax {
+				s.sendAPIError(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
 	}
-	log.Printf("API %s article: %d", r.Method, id)
+	if request.Limit <= 0 {
+		request.Limit = relatedLimit
+	}
+	request.Limit = min(request.Limit, searchLimitMax)
 
-	article, err := db.ArticleGet(id)
+	log.Printf("API %s article related: %d %s", r.Method, request.ID, request.DB)
+	results, err := ArticleRelated(r.Context(), request.DB, request.ID, request.Limit)
+	if err != nil {
+		s.sendAPIError(w, fmt.Sprintf("Error retrieving related articles: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Status:  "success",
+		Results: &results,
+		Time:    time.Since(startTime).Seconds(),
+	})
+}
+
+func (s *WebServer) handleAPISection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var request APIRequest
+	var err error
+
+	startTime := time.Now()
+
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			s.sendAPIError(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+	} else {
+		request.DB = r.URL.Query().Get("db")
+		idStr := r.URL.Query().Get("id")
+		if idStr == "" {
+			s.sendAPIError(w, "ID parameter is required", http.StatusBadRequest)
+			return
+		}
+		request.ID, err = strconv.Atoi(idStr)
+		if err != nil {
+			s.sendAPIError(w, "Invalid ID parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	log.Printf("API %s section: %d %s", r.Method, request.ID, request.DB)
+	section, err := SectionGet(r.Context(), request.DB, request.ID)
+	if err != nil {
+		s.sendAPIError(w, fmt.Sprintf("Error retrieving section: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Status:  "success",
+		Section: &section,
+		Time:    time.Since(startTime).Seconds(),
+	})
+}
+
+func (s *WebServer) handleAPIArticleRandom(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	startTime := time.Now()
+
+	article, err := ArticleGetRandom(r.Context(), r.URL.Query().Get("db"))
 	if err != nil {
 		s.sendAPIError(w, fmt.Sprintf("Error retrieving article: %v", err), http.StatusInternalServerError)
 		return
@@ -239,6 +633,241 @@ func (s *WebServer) handleAPIArticle(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *WebServer) handleHTMLRandom(w http.ResponseWriter, r *http.Request) {
+	e := engineGet(r.FormValue("db"))
+	if e == nil {
+		http.Error(w, "database not found", http.StatusNotFound)
+		return
+	}
+
+	articleID, err := e.ArticleIDRandom(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("article?id=%d&db=%s", articleID, url.QueryEscape(e.ID)), http.StatusFound)
+}
+
+func (s *WebServer) handleAPIInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	startTime := time.Now()
+
+	e := engineGet(r.URL.Query().Get("db"))
+	if e == nil {
+		s.sendAPIError(w, "Invalid DB parameter", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := e.Stats(r.Context())
+	if err != nil {
+		s.sendAPIError(w, fmt.Sprintf("Error retrieving database statistics: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Status: "success",
+		Stats:  &stats,
+		Time:   time.Since(startTime).Seconds(),
+	})
+}
+
+// handleAPIAnswer returns the sentences of the top search results that best
+// answer a question, with their article and section.
+func (s *WebServer) handleAPIAnswer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var request APIRequest
+	var err error
+
+	startTime := time.Now()
+	limit := answerLimit
+
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			s.sendAPIError(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		if request.Limit > 0 {
+			limit = min(request.Limit, searchLimitMax)
+		}
+	} else {
+		request.Question = r.URL.Query().Get("question")
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 || limit > searchLimitMax {
+				s.sendAPIError(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	log.Printf("API %s answer: %s", r.Method, request.Question)
+
+	if request.Question == "" {
+		s.sendAPIError(w, "Question parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	answers, err := Answer(r.Context(), request.Question, limit)
+	if err != nil {
+		s.sendAPIError(w, fmt.Sprintf("Answer error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(APIResponse{
+		Status:  "success",
+		Answers: &answers,
+		Time:    time.Since(startTime).Seconds(),
+	})
+}
+
+// handleAPIAsk streams the answer of the chat model to a question as server
+// sent events: the sources it reads first, then every piece of the answer and
+// a final done event, or an error event when the answer breaks off.
+func (s *WebServer) handleAPIAsk(w http.ResponseWriter, r *http.Request) {
+	var request APIRequest
+
+	startTime := time.Now()
+
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			s.sendAPIError(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+	} else {
+		request.Question = r.URL.Query().Get("question")
+	}
+	log.Printf("API %s ask: %s", r.Method, request.Question)
+
+	if request.Question == "" {
+		s.sendAPIError(w, "Question parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	e := engineChat()
+	if e == nil {
+		s.sendAPIError(w, "Chat model is not available", http.StatusBadRequest)
+		return
+	}
+
+	sources, err := AskSources(r.Context(), request.Question)
+	if err != nil {
+		s.sendAPIError(w, fmt.Sprintf("Ask error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	s.sendAPIEvent(w, "sources", APIResponse{
+		Status:  "success",
+		Sources: &sources,
+		Time:    time.Since(startTime).Seconds(),
+	})
+
+	err = e.Ask(r.Context(), request.Question, sources, func(text string) error {
+		return s.sendAPIEvent(w, "token", APIResponse{
+			Status: "success",
+			Text:   text,
+			Time:   time.Since(startTime).Seconds(),
+		})
+	})
+	if err != nil {
+		if r.Context().Err() == nil {
+			s.sendAPIEvent(w, "error", APIResponse{
+				Status:  "error",
+				Message: fmt.Sprintf("Ask error: %v", err),
+				Time:    time.Since(startTime).Seconds(),
+			})
+		}
+		return
+	}
+
+	s.sendAPIEvent(w, "done", APIResponse{
+		Status: "success",
+		Time:   time.Since(startTime).Seconds(),
+	})
+}
+
+// handleAPISuggest completes a title prefix, with format=opensearch it answers
+// with the OpenSearch suggestions array used by browsers.
+func (s *WebServer) handleAPISuggest(w http.ResponseWriter, r *http.Request) {
+	var request APIRequest
+	var err error
+
+	startTime := time.Now()
+	limit := 10
+
+	if r.Method == "POST" {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			s.sendAPIError(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		if request.Limit > 0 {
+			limit = min(request.Limit, searchLimitMax)
+		}
+	} else {
+		request.Prefix = r.URL.Query().Get("prefix")
+		request.Format = r.URL.Query().Get("format")
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 || limit > searchLimitMax {
+				w.Header().Set("Content-Type", "application/json")
+				s.sendAPIError(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	results, err := Suggest(r.Context(), request.Prefix, limit)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		s.sendAPIError(w, fmt.Sprintf("Suggest error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if request.Format == "opensearch" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		titles := []string{}
+		descriptions := []string{}
+		urls := []string{}
+		for _, result := range results {
+			titles = append(titles, result.Title)
+			descriptions = append(descriptions, "")
+			urls = append(urls, fmt.Sprintf("%s://%s/article?id=%d&db=%s", scheme, r.Host, result.ArticleID, url.QueryEscape(result.DB)))
+		}
+		w.Header().Set("Content-Type", "application/x-suggestions+json")
+		json.NewEncoder(w).Encode([]interface{}{request.Prefix, titles, descriptions, urls})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Status:  "success",
+		Results: &results,
+		Time:    time.Since(startTime).Seconds(),
+	})
+}
+
+func (s *WebServer) handleOpenSearch(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := html.EscapeString(scheme + "://" + r.Host)
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+  <ShortName>Wikilite</ShortName>
+  <Description>Offline Wikipedia search</Description>
+  <InputEncoding>UTF-8</InputEncoding>
+  <Url type="text/html" method="get" template="%s/?query={searchTerms}"/>
+  <Url type="application/x-suggestions+json" method="get" template="%s/api/suggest?format=opensearch&amp;prefix={searchTerms}"/>
+</OpenSearchDescription>
+`, base, base)
+}
+
 func (s *WebServer) handleHome(w http.ResponseWriter, r *http.Request) {
 	s.handleHTMLSearch(w, r)
 }
@@ -248,6 +877,7 @@ func (s *WebServer) Start(host string, port int) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", s.handleHome)
 	mux.HandleFunc("/article", s.handleHTMLArticle)
+	mux.HandleFunc("/random", s.handleHTMLRandom)
 
 	mux.HandleFunc("/api/search", s.handleAPISearch)
 	mux.HandleFunc("/api/search/title", s.handleAPISearchTitle)
@@ -255,6 +885,14 @@ func (s *WebServer) Start(host string, port int) error {
 	mux.HandleFunc("/api/search/semantic", s.handleAPISearchSemantic)
 	mux.HandleFunc("/api/search/distance", s.handleAPISearchWordDistance)
 	mux.HandleFunc("/api/article", s.handleAPIArticle)
+	mux.HandleFunc("/api/article/random", s.handleAPIArticleRandom)
+	mux.HandleFunc("/api/article/related", s.handleAPIArticleRelated)
+	mux.HandleFunc("/api/section", s.handleAPISection)
+	mux.HandleFunc("/api/info", s.handleAPIInfo)
+	mux.HandleFunc("/api/suggest", s.handleAPISuggest)
+	mux.HandleFunc("/api/answer", s.handleAPIAnswer)
+	mux.HandleFunc("/api/ask", s.handleAPIAsk)
+	mux.HandleFunc("/opensearch.xml", s.handleOpenSearch)
 
 	subFS, err := fs.Sub(assets, "assets/static")
 	if err != nil {
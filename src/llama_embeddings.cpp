@@ -7,11 +7,21 @@
 #include <vector>
 #include <string>
 #include <cstring> 
+#include <algorithm>
 
 static common_params g_params;
 static llama_model* g_model = nullptr;
 static llama_context* g_ctx = nullptr;
 static bool g_initialized = false;
+static llama_model* g_rerank_model = nullptr;
+static llama_context* g_rerank_ctx = nullptr;
+static bool g_rerank_initialized = false;
+static llama_model* g_chat_model = nullptr;
+static llama_context* g_chat_ctx = nullptr;
+static llama_sampler* g_chat_sampler = nullptr;
+static bool g_chat_initialized = false;
+static int g_chat_remaining = 0;
+static llama_token g_chat_token = LLAMA_TOKEN_NULL;
 static void* g_copied_buffer = nullptr;
 static size_t g_copied_size = 0;
 
@@ -229,3 +239,287 @@ int llama_embeddings_get_dimension(void) {
     if (!g_initialized) return -1;
     return llama_model_n_embd(g_model);
 }
+
+// The reranker is a second model, loaded with rank pooling: the sequence
+// output of a query/document pair is its relevance score.
+int llama_rerank_init(const char* model_path, int n_threads) {
+    llama_log_set(silent_log_callback, NULL);
+
+    if (g_rerank_initialized) {
+        return 0;
+    }
+
+    #if defined(_WIN32)
+        ggml_backend_load_all();
+    #else
+    if (strcmp(model_path, "memory:") == 0) {
+        if (g_memory_file.buf == nullptr) {
+            fprintf(stderr, "Error: 'memory:' path specified but buffer not set. Call llama_copy_memory_buffer first.\n");
+            return 1;
+        }
+    }
+    #endif
+
+    if (n_threads <= 0) n_threads = 1;
+
+    common_params params = {};
+    params.model.path = model_path;
+    params.embedding = true;
+    params.pooling_type = LLAMA_POOLING_TYPE_RANK;
+    params.warmup = false;
+    params.cpuparams.n_threads = n_threads;
+    params.cpuparams_batch.n_threads = n_threads;
+    params.n_ctx = 512;
+    params.n_batch = 512;
+    params.n_ubatch = 512;
+    params.n_gpu_layers = 0;
+    params.use_mmap = false;
+
+    common_init_result llama_init = common_init_from_params(params);
+    g_rerank_model = llama_init.model.release();
+    g_rerank_ctx = llama_init.context.release();
+
+    if (g_rerank_model == nullptr || g_rerank_ctx == nullptr) {
+        llama_log_set(NULL, NULL);
+        fprintf(stderr, "Error: Failed to initialize rerank model or context from '%s'\n", model_path);
+        if (g_rerank_ctx) llama_free(g_rerank_ctx);
+        if (g_rerank_model) llama_model_free(g_rerank_model);
+        g_rerank_model = nullptr;
+        g_rerank_ctx = nullptr;
+        return 1;
+    }
+
+    if (llama_pooling_type(g_rerank_ctx) != LLAMA_POOLING_TYPE_RANK) {
+        fprintf(stderr, "Error: '%s' is not a rerank model\n", model_path);
+        llama_free(g_rerank_ctx);
+        llama_model_free(g_rerank_model);
+        g_rerank_model = nullptr;
+        g_rerank_ctx = nullptr;
+        return 1;
+    }
+
+    g_rerank_initialized = true;
+    return 0;
+}
+
+int llama_rerank_score(const char* query, const char* document, float* score_out) {
+    if (!g_rerank_initialized || !query || !document || !score_out) {
+        return 1;
+    }
+
+    const llama_vocab* vocab = llama_model_get_vocab(g_rerank_model);
+    std::vector<llama_token> query_tokens = common_tokenize(vocab, query, false, false);
+    std::vector<llama_token> document_tokens = common_tokenize(vocab, document, false, false);
+
+    std::vector<llama_token> tokens;
+    tokens.reserve(query_tokens.size() + document_tokens.size() + 4);
+    if (llama_vocab_get_add_bos(vocab)) tokens.push_back(llama_vocab_bos(vocab));
+    tokens.insert(tokens.end(), query_tokens.begin(), query_tokens.end());
+    if (llama_vocab_get_add_eos(vocab)) tokens.push_back(llama_vocab_eos(vocab));
+    if (llama_vocab_get_add_sep(vocab)) tokens.push_back(llama_vocab_sep(vocab));
+    tokens.insert(tokens.end(), document_tokens.begin(), document_tokens.end());
+    if (llama_vocab_get_add_eos(vocab)) tokens.push_back(llama_vocab_eos(vocab));
+
+    const int max_context_tokens = llama_n_ctx(g_rerank_ctx);
+    if ((int)tokens.size() > max_context_tokens) {
+        tokens.resize(max_context_tokens);
+    }
+
+    llama_batch batch = llama_batch_init(tokens.size(), 0, 1);
+    for (size_t i = 0; i < tokens.size(); ++i) {
+        common_batch_add(batch, tokens[i], i, { 0 }, true);
+    }
+
+    llama_memory_clear(llama_get_memory(g_rerank_ctx), true);
+
+    if (llama_decode(g_rerank_ctx, batch) < 0) {
+        fprintf(stderr, "Error: llama_decode failed\n");
+        llama_batch_free(batch);
+        return 1;
+    }
+
+    const float* score = llama_get_embeddings_seq(g_rerank_ctx, 0);
+    if (score == nullptr) {
+        fprintf(stderr, "Error: failed to get rerank score\n");
+        llama_batch_free(batch);
+        return 1;
+    }
+
+    *score_out = score[0];
+    llama_batch_free(batch);
+    return 0;
+}
+
+void llama_rerank_free(void) {
+    if (g_rerank_initialized) {
+        if (g_rerank_ctx) llama_free(g_rerank_ctx);
+        if (g_rerank_model) llama_model_free(g_rerank_model);
+        g_rerank_model = NULL;
+        g_rerank_ctx = NULL;
+        g_rerank_initialized = false;
+    }
+}
+
+// The chat model is a third one, an instruct GGUF generating text one token at
+// a time: llama_chat_start decodes the prompt formatted with the model chat
+// template, each llama_chat_next call then samples the next piece of text.
+int llama_chat_init(const char* model_path, int n_threads) {
+    llama_log_set(silent_log_callback, NULL);
+
+    if (g_chat_initialized) {
+        return 0;
+    }
+
+    #if defined(_WIN32)
+        ggml_backend_load_all();
+    #else
+    if (strcmp(model_path, "memory:") == 0) {
+        if (g_memory_file.buf == nullptr) {
+            fprintf(stderr, "Error: 'memory:' path specified but buffer not set. Call llama_copy_memory_buffer first.\n");
+            return 1;
+        }
+    }
+    #endif
+
+    if (n_threads <= 0) n_threads = 1;
+
+    common_params params = {};
+    params.model.path = model_path;
+    params.warmup = false;
+    params.cpuparams.n_threads = n_threads;
+    params.cpuparams_batch.n_threads = n_threads;
+    params.n_ctx = 4096;
+    params.n_batch = 4096;
+    params.n_ubatch = 512;
+    params.n_gpu_layers = 0;
+    params.use_mmap = false;
+
+    common_init_result llama_init = common_init_from_params(params);
+    g_chat_model = llama_init.model.release();
+    g_chat_ctx = llama_init.context.release();
+
+    if (g_chat_model == nullptr || g_chat_ctx == nullptr) {
+        llama_log_set(NULL, NULL);
+        fprintf(stderr, "Error: Failed to initialize chat model or context from '%s'\n", model_path);
+        if (g_chat_ctx) llama_free(g_chat_ctx);
+        if (g_chat_model) llama_model_free(g_chat_model);
+        g_chat_model = nullptr;
+        g_chat_ctx = nullptr;
+        return 1;
+    }
+
+    if (llama_model_chat_template(g_chat_model, nullptr) == nullptr) {
+        fprintf(stderr, "Error: '%s' has no chat template\n", model_path);
+        llama_free(g_chat_ctx);
+        llama_model_free(g_chat_model);
+        g_chat_model = nullptr;
+        g_chat_ctx = nullptr;
+        return 1;
+    }
+
+    g_chat_sampler = llama_sampler_chain_init(llama_sampler_chain_default_params());
+    llama_sampler_chain_add(g_chat_sampler, llama_sampler_init_min_p(0.05f, 1));
+    llama_sampler_chain_add(g_chat_sampler, llama_sampler_init_temp(0.2f));
+    llama_sampler_chain_add(g_chat_sampler, llama_sampler_init_dist(LLAMA_DEFAULT_SEED));
+
+    g_chat_initialized = true;
+    return 0;
+}
+
+int llama_chat_start(const char* system, const char* prompt, int max_tokens) {
+    if (!g_chat_initialized || !system || !prompt) {
+        return 1;
+    }
+
+    const char* tmpl = llama_model_chat_template(g_chat_model, nullptr);
+    llama_chat_message messages[] = {
+        { "system", system },
+        { "user", prompt },
+    };
+    std::vector<char> formatted(strlen(system) + strlen(prompt) + 1024);
+    int length = llama_chat_apply_template(tmpl, messages, 2, true, formatted.data(), formatted.size());
+    if (length > (int)formatted.size()) {
+        formatted.resize(length);
+        length = llama_chat_apply_template(tmpl, messages, 2, true, formatted.data(), formatted.size());
+    }
+    if (length < 0) {
+        fprintf(stderr, "Error: failed to apply the chat template\n");
+        return 1;
+    }
+
+    const llama_vocab* vocab = llama_model_get_vocab(g_chat_model);
+    std::vector<llama_token> tokens = common_tokenize(vocab, std::string(formatted.data(), length), true, true);
+
+    const int max_context_tokens = llama_n_ctx(g_chat_ctx);
+    if (max_tokens <= 0 || max_tokens >= max_context_tokens) {
+        max_tokens = max_context_tokens / 4;
+    }
+    if ((int)tokens.size() + max_tokens > max_context_tokens) {
+        fprintf(stderr, "Error: prompt of %zu tokens exceeds the context length (%d tokens)\n", tokens.size(), max_context_tokens);
+        return 1;
+    }
+
+    llama_memory_clear(llama_get_memory(g_chat_ctx), true);
+    llama_sampler_reset(g_chat_sampler);
+
+    const int n_batch = (int)llama_n_batch(g_chat_ctx);
+    for (size_t i = 0; i < tokens.size(); i += n_batch) {
+        const int n_tokens = std::min((int)(tokens.size() - i), n_batch);
+        if (llama_decode(g_chat_ctx, llama_batch_get_one(tokens.data() + i, n_tokens)) != 0) {
+            fprintf(stderr, "Error: llama_decode failed\n");
+            return 1;
+        }
+    }
+
+    g_chat_remaining = max_tokens;
+    g_chat_token = LLAMA_TOKEN_NULL;
+    return 0;
+}
+
+// llama_chat_next writes the next piece of the answer, not null terminated,
+// returning its length, 0 once the answer is over and -1 on errors.
+int llama_chat_next(char* piece, int size) {
+    if (!g_chat_initialized || !piece || size <= 0) {
+        return -1;
+    }
+    if (g_chat_remaining <= 0) {
+        return 0;
+    }
+
+    if (g_chat_token != LLAMA_TOKEN_NULL) {
+        if (llama_decode(g_chat_ctx, llama_batch_get_one(&g_chat_token, 1)) != 0) {
+            fprintf(stderr, "Error: llama_decode failed\n");
+            return -1;
+        }
+    }
+
+    const llama_vocab* vocab = llama_model_get_vocab(g_chat_model);
+    g_chat_token = llama_sampler_sample(g_chat_sampler, g_chat_ctx, -1);
+    if (llama_vocab_is_eog(vocab, g_chat_token)) {
+        g_chat_remaining = 0;
+        return 0;
+    }
+    g_chat_remaining--;
+
+    int length = llama_token_to_piece(vocab, g_chat_token, piece, size, 0, false);
+    if (length < 0) {
+        fprintf(stderr, "Error: token piece longer than %d bytes\n", size);
+        return -1;
+    }
+    if (length == 0) {
+        return llama_chat_next(piece, size);
+    }
+    return length;
+}
+
+void llama_chat_free(void) {
+    if (g_chat_initialized) {
+        if (g_chat_sampler) llama_sampler_free(g_chat_sampler);
+        if (g_chat_ctx) llama_free(g_chat_ctx);
+        if (g_chat_model) llama_model_free(g_chat_model);
+        g_chat_sampler = NULL;
+        g_chat_model = NULL;
+        g_chat_ctx = NULL;
+        g_chat_initialized = false;
+    }
+}
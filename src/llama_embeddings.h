@@ -23,6 +23,20 @@ void llama_embeddings_free(void);
 
 void llama_copy_memory_buffer(const void* buf, size_t size);
 
+int llama_rerank_init(const char* model_path, int n_threads);
+
+int llama_rerank_score(const char* query, const char* document, float* score_out);
+
+void llama_rerank_free(void);
+
+int llama_chat_init(const char* model_path, int n_threads);
+
+int llama_chat_start(const char* system, const char* prompt, int max_tokens);
+
+int llama_chat_next(char* piece, int size);
+
+void llama_chat_free(void);
+
 #ifdef __cplusplus
 }
 #endif
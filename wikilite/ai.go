@@ -0,0 +1,286 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type aiEmbeddingRequest struct {
+	Model          string      `json:"model"`
+	Input          interface{} `json:"input"`
+	EncodingFormat string      `json:"encoding_format,omitempty"`
+}
+
+type aiEmbeddingResponse struct {
+	Object string `json:"object"`
+	Data   []struct {
+		Object    string    `json:"object"`
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Model string `json:"model"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type aiRerankRequest struct {
+	Model     string   `json:"model"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+}
+
+type aiRerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type aiChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type aiChatRequest struct {
+	Model     string          `json:"model"`
+	Messages  []aiChatMessage `json:"messages"`
+	MaxTokens int             `json:"max_tokens,omitempty"`
+	Stream    bool            `json:"stream"`
+}
+
+type aiChatResponse struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type aiEmbedder struct {
+	config      *Config
+	model       func(ctx context.Context) []byte
+	rerankModel func(ctx context.Context) []byte
+	chatModel   func(ctx context.Context) []byte
+}
+
+func (a *aiEmbedder) Init(ctx context.Context) (err error) {
+	if _, err := a.Embeddings(ctx, "test"); err != nil {
+		return fmt.Errorf("AI error loading embedding model: %v", err)
+	}
+
+	return nil
+}
+
+func (a *aiEmbedder) RerankInit(ctx context.Context) (err error) {
+	if _, err := a.Rerank(ctx, "test", []string{"test"}); err != nil {
+		return fmt.Errorf("AI error loading rerank model: %v", err)
+	}
+
+	return nil
+}
+
+// apiChat streams the answer of a chat completions endpoint in the OpenAI
+// format, as served by llama.cpp, Ollama and most local servers, calling token
+// with every piece of text received.
+func (a *aiEmbedder) apiChat(ctx context.Context, system string, prompt string, maxTokens int, token func(string) error) error {
+	url := a.config.AiChatApiUrl
+	if url == "" {
+		return fmt.Errorf("no chat API url")
+	}
+	payload := aiChatRequest{
+		Model:     a.config.AiChatModel,
+		Messages:  []aiChatMessage{{Role: "system", Content: system}, {Role: "user", Content: prompt}},
+		MaxTokens: maxTokens,
+		Stream:    true,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.AiApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.config.AiApiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiResp aiChatResponse
+		if json.NewDecoder(resp.Body).Decode(&apiResp) == nil && apiResp.Error.Message != "" {
+			return fmt.Errorf("API error (%d): %s", resp.StatusCode, apiResp.Error.Message)
+		}
+		return fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk aiChatResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("failed to decode response chunk: %v", err)
+		}
+		if chunk.Error.Message != "" {
+			return fmt.Errorf("API error: %s", chunk.Error.Message)
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			if err := token(chunk.Choices[0].Delta.Content); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	return nil
+}
+
+// apiRerank scores documents against query with a rerank endpoint in the
+// format of the llama.cpp server, Jina and Cohere APIs.
+func (a *aiEmbedder) apiRerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	url := a.config.AiRerankApiUrl
+	if url == "" {
+		return nil, fmt.Errorf("no rerank API url")
+	}
+	payload := aiRerankRequest{
+		Model:     a.config.AiRerankModel,
+		Query:     query,
+		Documents: documents,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rerank request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.AiApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.config.AiApiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var apiResp aiRerankResponse
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&apiResp); decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode response (status %d): %v", resp.StatusCode, decodeErr)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if apiResp.Error.Message != "" {
+			return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiResp.Error.Message)
+		}
+		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	}
+
+	if len(apiResp.Results) != len(documents) {
+		return nil, fmt.Errorf("rerank returned %d scores for %d documents", len(apiResp.Results), len(documents))
+	}
+
+	scores := make([]float64, len(documents))
+	for _, result := range apiResp.Results {
+		if result.Index < 0 || result.Index >= len(documents) {
+			return nil, fmt.Errorf("rerank returned an invalid index %d", result.Index)
+		}
+		scores[result.Index] = result.RelevanceScore
+	}
+
+	return scores, nil
+}
+
+func (a *aiEmbedder) apiEmbeddings(ctx context.Context, input string) (output []float32, err error) {
+	url := a.config.AiApiUrl
+	payload := aiEmbeddingRequest{
+		Model:          a.config.AiModel,
+		Input:          input,
+		EncodingFormat: "float",
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.AiApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.config.AiApiKey)
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var apiResp aiEmbeddingResponse
+	if decodeErr := json.NewDecoder(resp.Body).Decode(&apiResp); decodeErr != nil {
+		return nil, fmt.Errorf("failed to decode response (status %d): %v", resp.StatusCode, decodeErr)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if apiResp.Error.Message != "" {
+			return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiResp.Error.Message)
+		}
+		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	}
+
+	if len(apiResp.Data) == 0 {
+		return nil, fmt.Errorf("no embeddings returned")
+	}
+
+	return apiResp.Data[0].Embedding, nil
+}
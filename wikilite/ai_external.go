@@ -0,0 +1,23 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+//go:build !aiInternal
+
+package wikilite
+
+import "context"
+
+func aiInternal() bool {
+	return false
+}
+
+func (a *aiEmbedder) Embeddings(ctx context.Context, input string) ([]float32, error) {
+	return a.apiEmbeddings(ctx, input)
+}
+
+func (a *aiEmbedder) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	return a.apiRerank(ctx, query, documents)
+}
+
+func (a *aiEmbedder) Chat(ctx context.Context, system string, prompt string, maxTokens int, token func(string) error) error {
+	return a.apiChat(ctx, system, prompt, maxTokens, token)
+}
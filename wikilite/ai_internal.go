@@ -0,0 +1,308 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+//go:build aiInternal
+
+package wikilite
+
+/*
+#cgo CFLAGS: -I../src
+#cgo linux LDFLAGS: -L../build/bin -lembedding_wrapper -lcommon -lllama -lggml -lggml-base -lggml-cpu -lstdc++ -lpthread -lm -ldl
+#cgo darwin LDFLAGS: -L../build/bin -lembedding_wrapper -lcommon -lllama -lggml -lggml-base -lggml-cpu -lstdc++ -framework Accelerate
+#cgo windows LDFLAGS: -L../build/bin -lembedding_wrapper -lcommon -lllama -lggml -lggml-base -lstdc++ -lws2_32 -lbcrypt -ladvapi32
+#include "llama_embeddings.h"
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// aiInternalChatPiece is the largest piece of text a single token decodes to.
+const aiInternalChatPiece = 256
+
+// The llama.cpp wrapper holds a single model per process, loaded from the
+// first database asking for it.
+var (
+	aiInternalInitOnce aiInternalOnce
+	aiInternalModel    string
+	aiInternalMutex    sync.Mutex
+
+	aiInternalRerankInitOnce aiInternalOnce
+	aiInternalRerankMutex    sync.Mutex
+
+	aiInternalChatInitOnce aiInternalOnce
+	aiInternalChatMutex    sync.Mutex
+
+	aiInternalLoadMutex sync.Mutex
+)
+
+// errAiInternalNoModel is returned when the database holds no model to load.
+var errAiInternalNoModel = errors.New("no model in the database")
+
+// aiInternalOnce loads a model once like sync.Once, and remembers the
+// outcome, except for a missing model, so that a database importing it
+// later can still load it.
+type aiInternalOnce struct {
+	mutex sync.Mutex
+	done  bool
+	err   error
+}
+
+func (o *aiInternalOnce) Do(load func() error) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if !o.done {
+		o.err = load()
+		o.done = !errors.Is(o.err, errAiInternalNoModel)
+	}
+	return o.err
+}
+
+func aiInternal() bool {
+	return true
+}
+
+func aiInternalInit(modelData []byte, threads int) error {
+	if modelData == nil {
+		return fmt.Errorf("No internal AI model available: %w", errAiInternalNoModel)
+	}
+	return aiInternalLoad(modelData, threads, func(modelPath *C.char, threads C.int) C.int {
+		return C.llama_embeddings_init(modelPath, threads)
+	})
+}
+
+// aiInternalRerankInit loads the rerank model next to the embedding one, the
+// memory buffer is only read while a model is loading.
+func aiInternalRerankInit(modelData []byte, threads int) error {
+	if modelData == nil {
+		return fmt.Errorf("No internal rerank model available: %w", errAiInternalNoModel)
+	}
+	return aiInternalLoad(modelData, threads, func(modelPath *C.char, threads C.int) C.int {
+		return C.llama_rerank_init(modelPath, threads)
+	})
+}
+
+func aiInternalChatInit(modelData []byte, threads int) error {
+	if modelData == nil {
+		return fmt.Errorf("No internal chat model available: %w", errAiInternalNoModel)
+	}
+	return aiInternalLoad(modelData, threads, func(modelPath *C.char, threads C.int) C.int {
+		return C.llama_chat_init(modelPath, threads)
+	})
+}
+
+func aiInternalLoad(modelData []byte, threads int, init func(modelPath *C.char, threads C.int) C.int) error {
+	aiInternalLoadMutex.Lock()
+	defer aiInternalLoadMutex.Unlock()
+
+	modelPath := "memory:"
+
+	if runtime.GOOS == "windows" {
+		tmpFile, err := os.CreateTemp("", "model-*.gguf")
+		if err != nil {
+			return err
+		}
+		defer tmpFile.Close()
+		defer os.Remove(tmpFile.Name())
+
+		if _, err := tmpFile.Write(modelData); err != nil {
+			return err
+		}
+		modelPath = tmpFile.Name()
+		modelData = nil
+	}
+
+	cModelPath := C.CString(modelPath)
+	cThreadNumber := C.int(threads)
+	defer C.free(unsafe.Pointer(cModelPath))
+
+	if modelData != nil {
+		cBuffer := C.CBytes(modelData)
+		defer C.free(cBuffer)
+		C.llama_copy_memory_buffer(cBuffer, C.size_t(len(modelData)))
+		cModelPath = C.CString(modelPath)
+		defer C.free(unsafe.Pointer(cModelPath))
+	}
+
+	if result := init(cModelPath, cThreadNumber); result != 0 {
+		return fmt.Errorf("failed to initialize llama model: error code %d", int(result))
+	}
+
+	return nil
+}
+
+func (a *aiEmbedder) Embeddings(ctx context.Context, input string) ([]float32, error) {
+	if a.config.AiApi {
+		return a.apiEmbeddings(ctx, input)
+	} else {
+		err := aiInternalInitOnce.Do(func() error {
+			aiInternalModel = a.config.AiModel
+			return aiInternalInit(a.model(ctx), a.config.AiThreads)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if aiInternalModel != a.config.AiModel {
+			return nil, fmt.Errorf("internal AI already loaded with model %s", aiInternalModel)
+		}
+		return aiInternalEmbeddings(input)
+	}
+}
+
+func (a *aiEmbedder) Rerank(ctx context.Context, query string, documents []string) ([]float64, error) {
+	if a.config.AiApi {
+		return a.apiRerank(ctx, query, documents)
+	}
+
+	err := aiInternalRerankInitOnce.Do(func() error {
+		return aiInternalRerankInit(a.rerankModel(ctx), a.config.AiThreads)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float64, len(documents))
+	for i, document := range documents {
+		score, err := aiInternalRerank(query, document)
+		if err != nil {
+			return nil, err
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
+
+func (a *aiEmbedder) Chat(ctx context.Context, system string, prompt string, maxTokens int, token func(string) error) error {
+	if a.config.AiApi {
+		return a.apiChat(ctx, system, prompt, maxTokens, token)
+	}
+
+	err := aiInternalChatInitOnce.Do(func() error {
+		return aiInternalChatInit(a.chatModel(ctx), a.config.AiThreads)
+	})
+	if err != nil {
+		return err
+	}
+	return aiInternalChat(ctx, system, prompt, maxTokens, token)
+}
+
+// aiInternalChat holds the chat model for the whole answer, a token may end
+// in the middle of a multibyte character so the text is passed on up to the
+// last complete one.
+func aiInternalChat(ctx context.Context, system string, prompt string, maxTokens int, token func(string) error) error {
+	aiInternalChatMutex.Lock()
+	defer aiInternalChatMutex.Unlock()
+
+	cSystem := C.CString(system)
+	defer C.free(unsafe.Pointer(cSystem))
+	cPrompt := C.CString(prompt)
+	defer C.free(unsafe.Pointer(cPrompt))
+
+	if C.llama_chat_start(cSystem, cPrompt, C.int(maxTokens)) != 0 {
+		return fmt.Errorf("failed to process the chat prompt")
+	}
+
+	cPiece := (*C.char)(C.malloc(aiInternalChatPiece))
+	defer C.free(unsafe.Pointer(cPiece))
+
+	var pending []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		length := C.llama_chat_next(cPiece, aiInternalChatPiece)
+		if length < 0 {
+			return fmt.Errorf("failed to generate the chat answer")
+		}
+		if length == 0 {
+			break
+		}
+		pending = append(pending, C.GoBytes(unsafe.Pointer(cPiece), length)...)
+
+		end := len(pending)
+		start := end - 1
+		for start > 0 && end-start < utf8.UTFMax && !utf8.RuneStart(pending[start]) {
+			start--
+		}
+		if !utf8.FullRune(pending[start:]) {
+			end = start
+		}
+		if end == 0 {
+			continue
+		}
+		if err := token(string(pending[:end])); err != nil {
+			return err
+		}
+		pending = pending[end:]
+	}
+	if len(pending) > 0 {
+		return token(string(pending))
+	}
+
+	return nil
+}
+
+func aiInternalRerank(query string, document string) (float64, error) {
+	aiInternalRerankMutex.Lock()
+	defer aiInternalRerankMutex.Unlock()
+
+	cQuery := C.CString(query)
+	defer C.free(unsafe.Pointer(cQuery))
+	cDocument := C.CString(document)
+	defer C.free(unsafe.Pointer(cDocument))
+
+	var score C.float
+	if C.llama_rerank_score(cQuery, cDocument, &score) != 0 {
+		return 0, fmt.Errorf("failed to rerank document: '%s'", document)
+	}
+
+	return float64(score), nil
+}
+
+func aiInternalEmbeddings(input string) ([]float32, error) {
+	aiInternalMutex.Lock()
+	defer aiInternalMutex.Unlock()
+
+	if C.llama_embeddings_get_dimension() < 1 {
+		return nil, fmt.Errorf("model not initialized or already freed")
+	}
+
+	cText := C.CString(input)
+	defer C.free(unsafe.Pointer(cText))
+
+	var nEmb int
+	nEmbPtr := (*C.int)(unsafe.Pointer(&nEmb))
+
+	cEmbedding := C.llama_embeddings_get(cText, nEmbPtr)
+
+	if cEmbedding == nil {
+		return nil, fmt.Errorf("failed to generate embedding for text: '%s'", input)
+	}
+	defer C.llama_embeddings_free_output(cEmbedding)
+
+	if nEmb <= 0 {
+		return nil, fmt.Errorf("embedding generation returned invalid size: %d", nEmb)
+	}
+
+	embeddingDim := int(C.llama_embeddings_get_dimension())
+	if embeddingDim <= 0 {
+		return nil, fmt.Errorf("failed to get embedding dimension")
+	}
+	totalFloats := nEmb * embeddingDim
+
+	goSlice := unsafe.Slice((*C.float)(unsafe.Pointer(cEmbedding)), totalFloats)
+	result := make([]float32, totalFloats)
+	for i, v := range goSlice {
+		result[i] = float32(v)
+	}
+
+	return result, nil
+}
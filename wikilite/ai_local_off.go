@@ -2,7 +2,7 @@
 
 //go:build !aiLocal
 
-package main
+package wikilite
 
 import "fmt"
 
@@ -10,7 +10,7 @@ func localAiEnabled() bool {
 	return false
 }
 
-func localAiInit(modelPath string) error {
+func localAiInit(modelPath string, threads int) error {
 	return fmt.Errorf("local embeddings are not supported on this platform")
 }
 
@@ -2,7 +2,7 @@
 
 //go:build aiLocal
 
-package main
+package wikilite
 
 /*
 #cgo CFLAGS: -I../src
@@ -23,9 +23,9 @@ func localAiEnabled() bool {
 	return true
 }
 
-func localAiInit(modelPath string) error {
+func localAiInit(modelPath string, threads int) error {
 	cModelPath := C.CString(modelPath)
-	cThreadNumber := C.int(options.aiThreads)
+	cThreadNumber := C.int(threads)
 	defer C.free(unsafe.Pointer(cModelPath))
 
 	if result := C.llama_embeddings_init(cModelPath, cThreadNumber); result != 0 {
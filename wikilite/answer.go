@@ -0,0 +1,181 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	answerSections     = 5
+	answerCandidates   = 40
+	answerCandidatesAI = 10
+	answerWordsMin     = 4
+)
+
+// Answer extracts from the top sections found by Search the limit sentences
+// that best answer question, searched as any of its words since a question
+// rarely has all of them in the answer. A sentence scores the share of the question
+// words it holds, each word weighing less the more frequent it is in the
+// vocabulary, averaged with its embedding similarity to the question when the
+// model is available, computed for the answerCandidatesAI best sentences only
+// and cached like the query embeddings.
+func (e *Engine) Answer(ctx context.Context, question string, limit int) ([]AnswerResult, error) {
+	results, err := e.Search(ctx, answerQuery(question), SearchOptions{Limit: answerSections})
+	if err != nil && !errors.Is(err, ErrSearchPartial) {
+		return nil, err
+	}
+
+	stem := e.db.snippetStemmer()
+	terms := snippetTerms(question, stem)
+	weights := make([]float64, len(terms))
+	total := 0.0
+	for i, term := range terms {
+		weights[i] = 1 / math.Log(2+float64(e.db.VocabularyFrequency(ctx, term.word)))
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	var answers []AnswerResult
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if result.SectionID == 0 {
+			continue
+		}
+		content, err := e.db.SectionContent(ctx, result.SectionID)
+		if err != nil {
+			return nil, err
+		}
+		for _, sentence := range answerSentences(content) {
+			if seen[sentence] {
+				continue
+			}
+			seen[sentence] = true
+
+			overlap := 0.0
+			words := strings.Fields(sentence)
+			for i := range terms {
+				for _, word := range words {
+					if snippetMatch(word, terms[i:i+1], stem) {
+						overlap += weights[i]
+						break
+					}
+				}
+			}
+			answers = append(answers, AnswerResult{
+				DB:           e.ID,
+				Text:         sentence,
+				Score:        overlap / total,
+				ArticleID:    result.ArticleID,
+				ArticleTitle: result.Title,
+				SectionID:    result.SectionID,
+				SectionTitle: result.SectionTitle,
+			})
+		}
+	}
+	answerSort(answers)
+	candidates := answerCandidates
+	if e.ai {
+		candidates = answerCandidatesAI
+	}
+	if len(answers) > candidates {
+		answers = answers[:candidates]
+	}
+
+	if e.ai && len(answers) > 0 {
+		questionEmbedding, err := e.db.queryEmbeddings(ctx, e.db.config.AiModelPrefixSearch+QueryText(question))
+		if err != nil {
+			return nil, err
+		}
+		for i := range answers {
+			embedding, err := e.db.queryEmbeddings(ctx, e.db.config.AiModelPrefixSave+answers[i].Text)
+			if err != nil {
+				return nil, err
+			}
+			similarity, err := CosineSimilarity(questionEmbedding, embedding)
+			if err != nil {
+				return nil, err
+			}
+			answers[i].Score = (answers[i].Score + math.Max(0, float64(similarity))) / 2
+		}
+		answerSort(answers)
+	}
+
+	if len(answers) > limit {
+		answers = answers[:limit]
+	}
+	return answers, nil
+}
+
+// answerQuery turns a question into a query matching any of its words and
+// phrases, keeping their fields, prefixes and exclusions.
+func answerQuery(question string) string {
+	if strings.HasPrefix(strings.TrimSpace(question), QueryRawPrefix) {
+		return question
+	}
+
+	var words, excluded []string
+	for _, term := range queryParse(question) {
+		text := `"` + strings.ReplaceAll(term.text, `"`, " ") + `"`
+		if term.prefix {
+			text += "*"
+		}
+		if term.field != "" {
+			text = term.field + ":" + text
+		}
+		if term.negate {
+			excluded = append(excluded, "-"+text)
+		} else {
+			words = append(words, text)
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+	return strings.Join(append([]string{strings.Join(words, " OR ")}, excluded...), " ")
+}
+
+// answerSort orders answers by score, ties keeping the rank of their section.
+func answerSort(answers []AnswerResult) {
+	sort.SliceStable(answers, func(i, j int) bool {
+		return answers[i].Score > answers[j].Score
+	})
+}
+
+// answerSentences splits text into sentences at line breaks and at . ! or ?
+// followed by a capital letter or a digit, unless the period closes an
+// initial. Sentences shorter than answerWordsMin words are left out.
+func answerSentences(text string) []string {
+	var sentences []string
+	add := func(runes []rune) {
+		sentence := strings.TrimSpace(string(runes))
+		if len(strings.Fields(sentence)) >= answerWordsMin {
+			sentences = append(sentences, sentence)
+		}
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(line)
+		start := 0
+		for i := 0; i+2 < len(runes); i++ {
+			if !strings.ContainsRune(".!?", runes[i]) || !unicode.IsSpace(runes[i+1]) || !(unicode.IsUpper(runes[i+2]) || unicode.IsDigit(runes[i+2])) {
+				continue
+			}
+			if runes[i] == '.' && i >= 1 && unicode.IsUpper(runes[i-1]) && (i == 1 || !unicode.IsLetter(runes[i-2])) {
+				continue
+			}
+			add(runes[start : i+1])
+			start = i + 1
+		}
+		add(runes[start:])
+	}
+
+	return sentences
+}
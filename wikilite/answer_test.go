@@ -0,0 +1,49 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnswerQuery(t *testing.T) {
+	tests := []struct {
+		question string
+		want     string
+		fts      string
+	}{
+		{`when was the "eiffel tower" built`, `"when" OR "was" OR "the" OR "eiffel tower" OR "built"`, `"when" OR "was" OR "the" OR "eiffel tower" OR "built"`},
+		{"who wrote linux -windows", `"who" OR "wrote" OR "linux" -"windows"`, `("who" OR "wrote" OR "linux") NOT ("windows")`},
+		{"title:linux kern* OR unix", `title:"linux" OR "kern"* OR "unix"`, `title : "linux" OR "kern"* OR "unix"`},
+		{`what is a"b`, `"what" OR "is" OR "a b"`, `"what" OR "is" OR "a b"`},
+		{"-linux", "", ""},
+		{"fts:linux OR unix", "fts:linux OR unix", "linux OR unix"},
+	}
+	for _, test := range tests {
+		got := answerQuery(test.question)
+		if got != test.want {
+			t.Errorf("answerQuery(%q) = %q, want %q", test.question, got, test.want)
+		}
+		if fts := QueryFTS(got, "title", "content"); fts != test.fts {
+			t.Errorf("QueryFTS(answerQuery(%q)) = %q, want %q", test.question, fts, test.fts)
+		}
+	}
+}
+
+func TestAnswerSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"The tower was built in 1889. It is 330 metres tall!", []string{"The tower was built in 1889.", "It is 330 metres tall!"}},
+		{"Written by J. R. R. Tolkien in England. Too short.", []string{"Written by J. R. R. Tolkien in England."}},
+		{"First line of text\nsecond line of text", []string{"First line of text", "second line of text"}},
+		{"Version 2.0 was released in may", []string{"Version 2.0 was released in may"}},
+	}
+	for _, test := range tests {
+		if got := answerSentences(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("answerSentences(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	askSourceWords = 300
+	askTokens      = 512
+	askSystem      = "You answer questions using only the numbered sources given by the user, excerpts of encyclopedia articles. " +
+		"Cite the sources supporting each statement with their number in square brackets, like [1]. " +
+		"If the sources do not hold the answer, say so instead of guessing. " +
+		"Answer briefly, in the language of the question."
+)
+
+// AskSources returns the limit sections found by Search for question, searched
+// as any of its words like in Answer, numbered from 1 and cut to their first
+// askSourceWords words.
+func (e *Engine) AskSources(ctx context.Context, question string, limit int) ([]AskSource, error) {
+	results, err := e.Search(ctx, answerQuery(question), SearchOptions{Limit: limit})
+	if err != nil && !errors.Is(err, ErrSearchPartial) {
+		return nil, err
+	}
+
+	var sources []AskSource
+	for _, result := range results {
+		if result.SectionID == 0 {
+			continue
+		}
+		content, err := e.db.SectionContent(ctx, result.SectionID)
+		if err != nil {
+			return nil, err
+		}
+		words := strings.Fields(content)
+		if len(words) > askSourceWords {
+			words = append(words[:askSourceWords], snippetEllipsis)
+		}
+		sources = append(sources, AskSource{
+			DB:           e.ID,
+			Number:       len(sources) + 1,
+			Score:        result.Score,
+			ArticleID:    result.ArticleID,
+			ArticleTitle: result.Title,
+			SectionID:    result.SectionID,
+			SectionTitle: result.SectionTitle,
+			Text:         strings.Join(words, " "),
+		})
+	}
+	return sources, nil
+}
+
+// Ask streams the answer of the chat model to question, read from sources and
+// citing them by number, calling token with every piece of text generated.
+// The sources may come from other databases, see AskSources.
+func (e *Engine) Ask(ctx context.Context, question string, sources []AskSource, token func(string) error) error {
+	if !e.Chat() {
+		return fmt.Errorf("chat model is not available")
+	}
+
+	var prompt strings.Builder
+	for _, source := range sources {
+		title := source.ArticleTitle
+		if source.SectionTitle != "" {
+			title += " > " + source.SectionTitle
+		}
+		fmt.Fprintf(&prompt, "[%d] %s\n%s\n\n", source.Number, title, source.Text)
+	}
+	fmt.Fprintf(&prompt, "Question: %s", question)
+
+	return e.db.ai.Chat(ctx, askSystem, prompt.String(), askTokens, token)
+}
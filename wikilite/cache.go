@@ -0,0 +1,97 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache is a least recently used map holding at most size entries, safe for
+// concurrent use. A cache of size zero or less stores nothing.
+type cache[V any] struct {
+	mu     sync.Mutex
+	name   string
+	size   int
+	order  *list.List
+	items  map[string]*list.Element
+	hits   uint64
+	misses uint64
+}
+
+type cacheEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newCache[V any](name string, size int) *cache[V] {
+	return &cache[V]{
+		name:  name,
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *cache[V]) Get(key string) (value V, found bool) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, found := c.items[key]
+	if !found {
+		c.misses++
+		return
+	}
+	c.hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry[V]).value, true
+}
+
+func (c *cache[V]) Put(key string, value V) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, found := c.items[key]; found {
+		element.Value.(*cacheEntry[V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry[V]{key, value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry[V]).key)
+	}
+}
+
+// Purge drops every entry, keeping the hit counters.
+func (c *cache[V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.items)
+}
+
+func (c *cache[V]) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Name:     c.name,
+		Entries:  c.order.Len(),
+		Capacity: c.size,
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+	if c.hits+c.misses > 0 {
+		stats.HitRate = float64(c.hits) / float64(c.hits+c.misses)
+	}
+	return stats
+}
@@ -0,0 +1,812 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// dbIdleConns is the number of idle connections kept open, one for every
+// retriever of a search.
+const dbIdleConns = 4
+
+// dbPragmas are run on every connection of the pool as it opens.
+var dbPragmas = []string{
+	"PRAGMA synchronous = OFF",
+	"PRAGMA journal_mode = OFF",
+	"PRAGMA foreign_keys = OFF",
+	"PRAGMA cache_size = -10000",
+	"PRAGMA mmap_size = 268435456",
+	"PRAGMA temp_store = MEMORY",
+}
+
+// The driver exposes to SQL the Go functions needed to rebuild the full text
+// indexes: wikilite_stem(language, text) and wikilite_inflate(content_flate).
+func init() {
+	sql.Register("sqlite3_wikilite", &sqlite3.SQLiteDriver{
+		ConnectHook: dbFunctions,
+	})
+}
+
+func dbFunctions(conn *sqlite3.SQLiteConn) error {
+	if err := conn.RegisterFunc("wikilite_stem", TextStem, true); err != nil {
+		return err
+	}
+	return conn.RegisterFunc("wikilite_inflate", TextInflate, true)
+}
+
+// dbConnector opens the connections of a DBHandler pool with the driver
+// calling its connect hook.
+type dbConnector struct {
+	driver *sqlite3.SQLiteDriver
+	dsn    string
+}
+
+func (c *dbConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dbConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type DBHandler struct {
+	db         *sql.DB
+	config     *Config
+	ai         *aiEmbedder
+	embeddings *cache[[]float32]
+	results    *cache[[]SearchResult]
+	hnsw       *cache[*hnswNode]
+	codebooks  *cache[[][]float32]
+	queryOnly  atomic.Bool
+
+	version     *sql.Conn
+	dataVersion atomic.Int64
+}
+
+// connect prepares every new connection of the pool, since PRAGMAs only
+// apply to the connection running them: the Go functions, dbPragmas, and
+// query_only unless the database is being written.
+func (h *DBHandler) connect(conn *sqlite3.SQLiteConn) error {
+	if err := dbFunctions(conn); err != nil {
+		return err
+	}
+
+	queryOnly := "OFF"
+	if h.queryOnly.Load() {
+		queryOnly = "ON"
+	}
+	for _, pragma := range append(dbPragmas, "PRAGMA query_only = "+queryOnly) {
+		if _, err := conn.Exec(pragma, nil); err != nil {
+			return fmt.Errorf("error executing %s: %v", pragma, err)
+		}
+	}
+	return nil
+}
+
+// connectionsReset closes the idle connections, so that the next ones are
+// prepared by connect for the current mode.
+func (h *DBHandler) connectionsReset() {
+	h.db.SetMaxIdleConns(0)
+	h.db.SetMaxIdleConns(dbIdleConns)
+}
+
+func (h *DBHandler) initializeDB(ctx context.Context) error {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS setup (
+			key TEXT PRIMARY KEY,
+			value BLOB
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS articles (
+			id INTEGER PRIMARY KEY,
+			title TEXT NOT NULL,
+			entity TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS article_aliases (
+			article_id INTEGER NOT NULL,
+			title TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS article_suggest (
+			key TEXT NOT NULL,
+			article_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			rank INTEGER NOT NULL,
+			PRIMARY KEY (key, article_id)
+		) WITHOUT ROWID`,
+		`CREATE TABLE IF NOT EXISTS article_suggest_top (
+			prefix TEXT NOT NULL,
+			article_id INTEGER NOT NULL,
+			title TEXT NOT NULL,
+			rank INTEGER NOT NULL,
+			PRIMARY KEY (prefix, article_id)
+		) WITHOUT ROWID`,
+
+		`CREATE TABLE IF NOT EXISTS sections (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			article_id INTEGER,
+			title TEXT,
+			content TEXT,
+			content_flate BLOB,
+			pow INTEGER DEFAULT 0,
+			FOREIGN KEY(article_id) REFERENCES articles(id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS vocabulary (term TEXT)`,
+		`CREATE TABLE IF NOT EXISTS vocabulary_terms (
+			id INTEGER PRIMARY KEY,
+			term TEXT NOT NULL UNIQUE,
+			frequency INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS vocabulary_trigrams (
+			trigram TEXT NOT NULL,
+			term_id INTEGER NOT NULL,
+			PRIMARY KEY (trigram, term_id)
+		) WITHOUT ROWID`,
+		`CREATE TABLE IF NOT EXISTS vocabulary_vectors (
+			term TEXT PRIMARY KEY,
+			embedding BLOB
+		) WITHOUT ROWID`,
+
+		`CREATE TABLE IF NOT EXISTS vectors (
+			id INTEGER PRIMARY KEY,
+			embedding BLOB
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS article_vectors (
+			id INTEGER PRIMARY KEY,
+			embedding BLOB
+		)`,
+		`CREATE TABLE IF NOT EXISTS article_related (
+			article_id INTEGER NOT NULL,
+			related_id INTEGER NOT NULL,
+			distance REAL NOT NULL,
+			PRIMARY KEY (article_id, related_id)
+		) WITHOUT ROWID`,
+
+		`CREATE TABLE IF NOT EXISTS vectors_ann_chunks (
+			id INTEGER PRIMARY KEY,
+			chunk BLOB
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS vectors_ann_index (
+			id INTEGER PRIMARY KEY,
+			vectors_id INTEGER NOT NULL,
+			chunk_id INTEGER NOT NULL,
+			chunk_position INTEGER NOT NULL
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS vectors_hnsw (
+			id INTEGER PRIMARY KEY,
+			embedding BLOB,
+			neighbors BLOB
+		)`,
+
+		`CREATE INDEX IF NOT EXISTS idx_vectors_ann_index_chunk_id_position ON vectors_ann_index (chunk_id, chunk_position)`,
+		`CREATE INDEX IF NOT EXISTS idx_sections_article_id ON sections(article_id)`,
+	}
+	for _, query := range queries {
+		if _, err := h.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error executing query %s: %v", query, err)
+		}
+	}
+
+	if err := h.tokenizerInit(ctx); err != nil {
+		return err
+	}
+	h.suggestIndexesCheck(ctx)
+
+	if err := h.PragmaReadMode(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func NewDBHandler(ctx context.Context, config Config) (*DBHandler, error) {
+	handler := &DBHandler{
+		config:     &config,
+		embeddings: newCache[[]float32]("embeddings", config.CacheSize),
+		results:    newCache[[]SearchResult]("results", config.CacheSize),
+		hnsw:       newCache[*hnswNode]("hnsw", config.CacheSize*hnswCacheNodes),
+		codebooks:  newCache[[][]float32]("codebooks", min(config.CacheSize, annCodebooks)),
+	}
+	db := sql.OpenDB(&dbConnector{&sqlite3.SQLiteDriver{ConnectHook: handler.connect}, config.Path})
+	db.SetMaxIdleConns(dbIdleConns)
+	handler.db = db
+	handler.ai = &aiEmbedder{
+		config:      handler.config,
+		model:       handler.AiModelLoad,
+		rerankModel: handler.AiRerankModelLoad,
+		chatModel:   handler.AiChatModelLoad,
+	}
+	if err := handler.initializeDB(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	version, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	handler.version = version
+
+	if value, err := handler.SetupGet(ctx, "language"); err == nil && value != "" {
+		handler.config.Language = value
+	}
+
+	if value, err := handler.SetupGet(ctx, "model"); err == nil && value != "" {
+		handler.config.AiModel = value
+	}
+
+	annRebuild := config.AiAnn && config.AiAnnMode != ""
+	if value, err := handler.SetupGet(ctx, "annMode"); err == nil && value != "" && !annRebuild {
+		handler.config.AiAnnMode = value
+	}
+
+	if value, err := handler.SetupGet(ctx, "modelPrefixSearch"); err == nil && value != "" {
+		handler.config.AiModelPrefixSearch = value
+	}
+
+	if value, err := handler.SetupGet(ctx, "modelPrefixSave"); err == nil && value != "" {
+		handler.config.AiModelPrefixSave = value
+	}
+
+	if value, err := handler.SetupGet(ctx, "annSize"); err == nil && value != "" && !annRebuild {
+		handler.config.AiAnnSize = extractNumberFromString(value)
+	}
+
+	return handler, nil
+}
+
+// tokenizerInit creates the full text indexes of a new database with the
+// configured tokenizer, or reads the one of an existing database.
+func (h *DBHandler) tokenizerInit(ctx context.Context) error {
+	var exists int
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'article_search'").Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		h.config.Tokenizer, _ = h.SetupGet(ctx, "tokenizer")
+		return nil
+	}
+
+	tokenizer, err := tokenizerResolve(h.config.Tokenizer, h.config.Language)
+	if err != nil {
+		return err
+	}
+	if err := h.searchTablesCreate(ctx, tokenizer); err != nil {
+		return err
+	}
+	h.config.Tokenizer = tokenizer
+	if tokenizer == TokenizerDefault {
+		return nil
+	}
+
+	return h.SetupPut(ctx, "tokenizer", tokenizer)
+}
+
+func (h *DBHandler) searchTablesCreate(ctx context.Context, tokenizer string) error {
+	articleContent := "content='articles', content_rowid='id'"
+	sectionContent := "content='sections', content_rowid='id'"
+	if tokenizer == TokenizerStem {
+		articleContent = "content=''"
+		sectionContent = "content=''"
+	}
+
+	queries := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS article_search USING fts5(title, ` + articleContent + tokenizerOptions(tokenizer) + `)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS article_search_vocabulary USING fts5vocab(article_search, row)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS section_search USING fts5(title, content, ` + sectionContent + tokenizerOptions(tokenizer) + `)`,
+		`CREATE VIRTUAL TABLE IF NOT EXISTS section_search_vocabulary USING fts5vocab(section_search, row)`,
+	}
+	for _, query := range queries {
+		if _, err := h.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error creating search table: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Tokenize rebuilds the full text indexes and the vocabulary with another
+// tokenizer.
+func (h *DBHandler) Tokenize(ctx context.Context, tokenizer string) error {
+	tokenizer, err := tokenizerResolve(tokenizer, h.config.Language)
+	if err != nil {
+		return err
+	}
+	if tokenizer == h.config.Tokenizer {
+		return nil
+	}
+
+	for _, table := range []string{"article_search_vocabulary", "section_search_vocabulary", "article_search", "section_search"} {
+		if _, err := h.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			return fmt.Errorf("error dropping %s table: %v", table, err)
+		}
+	}
+	if err := h.searchTablesCreate(ctx, tokenizer); err != nil {
+		return err
+	}
+	h.config.Tokenizer = tokenizer
+	if err := h.SetupPut(ctx, "tokenizer", tokenizer); err != nil {
+		return err
+	}
+
+	if err := h.ProcessTitles(ctx); err != nil {
+		return err
+	}
+	if err := h.ProcessContents(ctx); err != nil {
+		return err
+	}
+	return h.ProcessVocabulary(ctx)
+}
+
+// stemmer returns the stemmer of the database language when the indexes are
+// stemmed in Go, nil otherwise.
+func (h *DBHandler) stemmer() func(string) string {
+	if h.config.Tokenizer != TokenizerStem {
+		return nil
+	}
+	return func(text string) string {
+		return TextStem(h.config.Language, text)
+	}
+}
+
+// snippetStemmer returns how words are normalized when matched by snippets
+// built in Go: stemmed or just folded like the tokenizer does.
+func (h *DBHandler) snippetStemmer() func(string) string {
+	switch h.config.Tokenizer {
+	case TokenizerStem:
+		return h.stemmer()
+	case TokenizerUnicode, TokenizerPorter:
+		return stemFold.Replace
+	}
+	return nil
+}
+
+func (h *DBHandler) Close() error {
+	h.version.Close()
+	return h.db.Close()
+}
+
+func (h *DBHandler) Pragma(ctx context.Context, pragmas []string) error {
+	for _, pragma := range pragmas {
+		if _, err := h.db.ExecContext(ctx, pragma); err != nil {
+			return fmt.Errorf("error executing PRAGMA %s: %v", pragma, err)
+		}
+	}
+	return nil
+}
+
+// PragmaReadMode makes every connection of the pool query only, closing
+// those left idle by import mode.
+func (h *DBHandler) PragmaReadMode(ctx context.Context) error {
+	h.queryOnly.Store(true)
+	h.connectionsReset()
+	return nil
+}
+
+// PragmaImportMode lets the connections of the pool write, the one taking
+// the exclusive lock is closed back in read mode.
+func (h *DBHandler) PragmaImportMode(ctx context.Context) error {
+	h.queryOnly.Store(false)
+	h.connectionsReset()
+	pragmas := []string{
+		"PRAGMA locking_mode = EXCLUSIVE",
+		"PRAGMA query_only = OFF",
+	}
+	return h.Pragma(ctx, pragmas)
+}
+
+// write runs fn with the database switched to import mode, dropping the
+// cached search results, embeddings, HNSW nodes and ANN codebooks that may no
+// longer hold.
+func (h *DBHandler) write(fn func() error) error {
+	ctx := context.Background()
+	if err := h.PragmaImportMode(ctx); err != nil {
+		return fmt.Errorf("error setting database in import mode: %v", err)
+	}
+	defer h.cachePurge()
+
+	if err := fn(); err != nil {
+		h.PragmaReadMode(ctx)
+		return err
+	}
+
+	if err := h.PragmaReadMode(ctx); err != nil {
+		return fmt.Errorf("error setting database in read mode: %v", err)
+	}
+
+	return nil
+}
+
+func (h *DBHandler) cachePurge() {
+	h.results.Purge()
+	h.hnsw.Purge()
+	h.codebooks.Purge()
+	h.embeddings.Purge()
+}
+
+// cacheValidate drops the caches when the database changed since the last
+// call, written by another process as well: PRAGMA data_version changes on
+// the h.version connection with every commit of the other connections.
+func (h *DBHandler) cacheValidate(ctx context.Context) {
+	var version int64
+	if err := h.version.QueryRowContext(ctx, "PRAGMA data_version").Scan(&version); err != nil {
+		return
+	}
+	if h.dataVersion.Swap(version) != version {
+		h.cachePurge()
+	}
+}
+
+func (h *DBHandler) Optimize(ctx context.Context) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	log.Println("Deleting duplicate sections")
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM sections
+		WHERE id NOT IN (
+			SELECT MAX(id)
+			FROM sections
+			GROUP BY article_id, title
+		)`)
+	if err != nil {
+		return fmt.Errorf("error deleting duplicate sections: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	log.Println("Running VACUUM")
+	_, err = h.db.ExecContext(ctx, "VACUUM")
+	if err != nil {
+		return fmt.Errorf("error executing VACUUM: %v", err)
+	}
+
+	return nil
+}
+
+func (h *DBHandler) SetupPut(ctx context.Context, key, value string) (err error) {
+	_, err = h.db.ExecContext(ctx, "INSERT OR REPLACE INTO setup (key, value) VALUES (?, ?)", key, value)
+	return
+}
+
+func (h *DBHandler) SetupGet(ctx context.Context, key string) (value string, err error) {
+	err = h.db.QueryRowContext(ctx, "SELECT value FROM setup WHERE key = ? LIMIT 1", key).Scan(&value)
+	return
+}
+
+func (h *DBHandler) ArticlePut(ctx context.Context, article OutputArticle) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT OR REPLACE INTO articles (id, title, entity) VALUES (?, ?, ?)",
+		article.ID, article.Title, article.Entity,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting article: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM article_aliases WHERE article_id = ?", article.ID); err != nil {
+		return fmt.Errorf("error deleting aliases: %v", err)
+	}
+	for _, alias := range article.Aliases {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO article_aliases (article_id, title) VALUES (?, ?)", article.ID, alias); err != nil {
+			return fmt.Errorf("error inserting alias: %v", err)
+		}
+	}
+
+	for _, item := range article.Items {
+		title, _ := item["title"].(string)
+		pow, _ := item["pow"].(int)
+		content, _ := item["content"].(string)
+
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO sections (article_id, title, content, pow) VALUES (?, ?, ?, ?)",
+			article.ID, title, content, pow,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting section: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (h *DBHandler) ArticleGet(ctx context.Context, articleID int) (ArticleResult, error) {
+	article := ArticleResult{
+		Sections: []ArticleResultSection{},
+	}
+
+	sqlQuery := `
+		SELECT
+			a.id,
+			a.title,
+			a.entity,
+			s.id,
+			s.title,
+			s.content
+		FROM
+			articles a
+		JOIN
+			sections s ON a.id = s.article_id
+		WHERE
+			a.id = ?
+		ORDER BY
+			s.id ASC;
+	`
+
+	rows, err := h.db.QueryContext(ctx, sqlQuery, articleID)
+	if err != nil {
+		return article, fmt.Errorf("article query error: %v", err)
+	}
+	defer rows.Close()
+
+	var isFirstRow = true
+	for rows.Next() {
+		var (
+			artID          int
+			artTitle       string
+			artEntity      string
+			section        ArticleResultSection
+			sectionContent sql.NullString
+		)
+
+		if err := rows.Scan(
+			&artID,
+			&artTitle,
+			&artEntity,
+			&section.ID,
+			&section.Title,
+			&sectionContent,
+		); err != nil {
+			return article, fmt.Errorf("error scanning result: %v", err)
+		}
+
+		if sectionContent.Valid {
+			section.Content = sectionContent.String
+		} else {
+			var content_flate []byte
+			if err := h.db.QueryRowContext(ctx, "SELECT content_flate FROM sections WHERE id = ?", section.ID).Scan(&content_flate); err == nil && content_flate != nil {
+				if content, err := TextInflate(content_flate); err == nil {
+					section.Content = content
+				}
+			}
+		}
+
+		if isFirstRow {
+			article.ID = artID
+			article.Title = artTitle
+			article.Entity = artEntity
+			isFirstRow = false
+		}
+
+		article.Sections = append(article.Sections, section)
+	}
+
+	if article.ID == 0 {
+		return article, fmt.Errorf("article not found")
+	}
+
+	return article, nil
+}
+
+func (h *DBHandler) SectionGet(ctx context.Context, sectionID int) (SectionResult, error) {
+	var section SectionResult
+	var title sql.NullString
+	err := h.db.QueryRowContext(ctx, "SELECT s.id, s.title, s.article_id, a.title FROM sections s JOIN articles a ON a.id = s.article_id WHERE s.id = ?", sectionID).Scan(
+		&section.ID,
+		&title,
+		&section.ArticleID,
+		&section.ArticleTitle,
+	)
+	if err == sql.ErrNoRows {
+		return section, fmt.Errorf("section not found")
+	} else if err != nil {
+		return section, fmt.Errorf("section query error: %v", err)
+	}
+	section.Title = title.String
+
+	section.Content, err = h.SectionContent(ctx, sectionID)
+	if err != nil {
+		return section, fmt.Errorf("error reading section content: %v", err)
+	}
+
+	return section, nil
+}
+
+// SectionContent returns the content of a section, inflating it when compressed.
+func (h *DBHandler) SectionContent(ctx context.Context, sectionID int) (string, error) {
+	var content sql.NullString
+	var contentFlate []byte
+	if err := h.db.QueryRowContext(ctx, "SELECT content, content_flate FROM sections WHERE id = ?", sectionID).Scan(&content, &contentFlate); err != nil {
+		return "", err
+	}
+	if content.Valid {
+		return content.String, nil
+	}
+	if contentFlate != nil {
+		return TextInflate(contentFlate)
+	}
+	return "", nil
+}
+
+func (h *DBHandler) ArticleIDByEntity(ctx context.Context, entity string) (articleID int, err error) {
+	err = h.db.QueryRowContext(ctx, "SELECT id FROM articles WHERE entity = ? LIMIT 1", entity).Scan(&articleID)
+	return
+}
+
+func (h *DBHandler) ArticleIDByTitle(ctx context.Context, title string) (articleID int, err error) {
+	err = h.db.QueryRowContext(ctx, `
+		SELECT id
+		FROM articles
+		WHERE title = ? COLLATE NOCASE
+		ORDER BY title = ? DESC, id ASC
+		LIMIT 1`, title, title).Scan(&articleID)
+	if err == sql.ErrNoRows {
+		err = h.db.QueryRowContext(ctx, `
+			SELECT article_id
+			FROM article_aliases
+			WHERE title = ? COLLATE NOCASE
+			ORDER BY title = ? DESC, article_id ASC
+			LIMIT 1`, title, title).Scan(&articleID)
+	}
+	return
+}
+
+func (h *DBHandler) ArticleIDRandom(ctx context.Context) (articleID int, err error) {
+	err = h.db.QueryRowContext(ctx, `
+		SELECT id
+		FROM articles
+		WHERE id >= (SELECT ABS(RANDOM()) % (MAX(id) + 1) FROM articles)
+		ORDER BY id ASC
+		LIMIT 1`).Scan(&articleID)
+	if err == sql.ErrNoRows {
+		err = h.db.QueryRowContext(ctx, "SELECT id FROM articles ORDER BY id ASC LIMIT 1").Scan(&articleID)
+	}
+	return
+}
+
+func (h *DBHandler) Compress(ctx context.Context) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var totalSections int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM sections WHERE content IS NOT NULL AND content != ''").Scan(&totalSections)
+	if err != nil {
+		return fmt.Errorf("error counting sections: %v", err)
+	}
+
+	log.Printf("Compressing %d sections", totalSections)
+
+	sectionRows, err := tx.QueryContext(ctx, "SELECT id, content FROM sections WHERE content IS NOT NULL AND content != ''")
+	if err != nil {
+		return fmt.Errorf("error querying sections: %v", err)
+	}
+	defer sectionRows.Close()
+
+	processed := 0
+	compressed := 0
+	var lastLogTime time.Time
+	batchStartTime := time.Now()
+
+	for sectionRows.Next() {
+		var id int
+		var content sql.NullString
+		if err := sectionRows.Scan(&id, &content); err != nil {
+			return fmt.Errorf("error scanning section: %v", err)
+		}
+
+		if content.Valid && content.String != "" {
+			compressedContent, err := TextDeflate(content.String)
+			if err != nil {
+				return fmt.Errorf("error compressing section content: %v", err)
+			}
+
+			if len(compressedContent) < len(content.String) {
+				_, err = tx.ExecContext(ctx, "UPDATE sections SET content_flate = ?, content = NULL WHERE id = ?", compressedContent, id)
+				if err != nil {
+					return fmt.Errorf("error updating section with compressed content: %v", err)
+				}
+				compressed++
+			}
+		}
+
+		processed++
+
+		now := time.Now()
+		if processed%10000 == 0 || now.Sub(lastLogTime) >= 5*time.Second {
+			progress := (float64(processed) / float64(totalSections) * 100)
+			elapsed := time.Since(batchStartTime)
+			estimatedTotal := time.Duration(float64(elapsed) / float64(processed) * float64(totalSections))
+			remaining := estimatedTotal - elapsed
+			log.Printf("Compression progress: %  .2f%% - ETA: %v", progress, remaining.Round(time.Second))
+			lastLogTime = now
+		}
+	}
+
+	if err := sectionRows.Err(); err != nil {
+		return fmt.Errorf("error iterating sections: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing compression transaction: %v", err)
+	}
+
+	log.Printf("Compression ready, starting VACUUM...")
+	_, err = h.db.ExecContext(ctx, "VACUUM")
+	if err != nil {
+		return fmt.Errorf("error executing VACUUM: %v", err)
+	}
+
+	return nil
+}
+
+// ErrVectorsDropped is returned by the processing that needs the section
+// vectors once VectorsDrop has deleted them.
+var ErrVectorsDropped = errors.New("the section vectors have been dropped")
+
+// vectorsDropped reports whether VectorsDrop has run on the database.
+func (h *DBHandler) vectorsDropped(ctx context.Context) bool {
+	value, _ := h.SetupGet(ctx, "vectorsDropped")
+	return value != ""
+}
+
+// VectorsDrop deletes the full section vectors of a database with an ANN
+// index, which then answers the semantic searches alone.
+func (h *DBHandler) VectorsDrop(ctx context.Context) error {
+	if !h.AiHasANN(ctx) {
+		return fmt.Errorf("the ANN index is required to drop the vectors")
+	}
+
+	log.Printf("Deleting the vectors")
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO setup (key, value) SELECT 'embeddingDimension', CAST(length(embedding) / 4 AS TEXT) FROM vectors LIMIT 1"); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error storing the embedding dimension: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM vectors"); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error deleting vectors: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO setup (key, value) VALUES ('vectorsDropped', '1')"); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error storing the vectors drop: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing the vectors drop: %v", err)
+	}
+
+	log.Printf("Running VACUUM")
+	if _, err := h.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("error executing VACUUM: %v", err)
+	}
+
+	return nil
+}
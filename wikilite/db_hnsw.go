@@ -0,0 +1,209 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	hnswBatch      = 1000
+	hnswCacheNodes = 20
+)
+
+// ProcessHNSW adds the section vectors missing from the HNSW graph, reduced
+// to Config.AiAnnSize dimensions when set, with Config.AiAnnM neighbours per
+// node found among Config.AiAnnEfConstruction candidates. The graph is
+// rebuilt from scratch only when its M changed. Nodes are read from
+// vectors_hnsw through the HNSW cache and the ones added or changed are
+// stored every hnswBatch insertions along with the entry point, so that an
+// interrupted build resumes from the last batch.
+func (h *DBHandler) ProcessHNSW(ctx context.Context) error {
+	size := h.config.AiAnnSize
+	m := h.config.AiAnnM
+	if m <= 0 {
+		m = hnswM
+	}
+	efConstruction := h.config.AiAnnEfConstruction
+	if efConstruction <= 0 {
+		efConstruction = hnswEfConstruction
+	}
+
+	var entry int64
+	if value, err := h.SetupGet(ctx, "annEntry"); err == nil {
+		entry, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if stored, _ := h.SetupGet(ctx, "annM"); entry != 0 && extractNumberFromString(stored) != m {
+		log.Printf("Dropping the HNSW graph with M %s", stored)
+		entry = 0
+	}
+	if entry != 0 {
+		if _, err := h.hnswNode(ctx, entry); errors.Is(err, sql.ErrNoRows) {
+			entry = 0
+		} else if err != nil {
+			return err
+		}
+	}
+	if entry == 0 {
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM vectors_hnsw"); err != nil {
+			return fmt.Errorf("error clearing vectors_hnsw table: %v", err)
+		}
+		h.hnsw.Purge()
+	}
+
+	var total int
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM vectors WHERE id NOT IN (SELECT id FROM vectors_hnsw)").Scan(&total); err != nil {
+		return fmt.Errorf("error counting vectors: %v", err)
+	}
+	if total == 0 {
+		log.Printf("No vectors to process")
+		return nil
+	}
+
+	log.Printf("Adding %d vectors to the HNSW graph with size %d, M %d and efConstruction %d...", total, size, m, efConstruction)
+
+	for key, value := range map[string]string{
+		"annMode":           "hnsw",
+		"annSize":           strconv.Itoa(size),
+		"annM":              strconv.Itoa(m),
+		"annEfConstruction": strconv.Itoa(efConstruction),
+	} {
+		if err := h.SetupPut(ctx, key, value); err != nil {
+			return err
+		}
+	}
+
+	builder := newHNSWBuilder(m, efConstruction, func(id int64) (*hnswNode, error) {
+		return h.hnswNode(ctx, id)
+	}, entry)
+	startTime := time.Now()
+	var lastID int64
+	for processed := 0; processed < total; {
+		rows, err := h.db.QueryContext(ctx, "SELECT id, embedding FROM vectors WHERE id > ? AND id NOT IN (SELECT id FROM vectors_hnsw) ORDER BY id LIMIT ?", lastID, hnswBatch)
+		if err != nil {
+			return fmt.Errorf("error loading vectors: %v", err)
+		}
+		var ids []int64
+		var embeddings [][]float32
+		for rows.Next() {
+			var id int64
+			var embedding []byte
+			if err := rows.Scan(&id, &embedding); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning vector row: %v", err)
+			}
+			ids = append(ids, id)
+			embeddings = append(embeddings, BytesToFloat32(ExtractMRL(BytesToFloat32(embedding), size)))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating vectors: %v", err)
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		for i, id := range ids {
+			if err := builder.insert(id, embeddings[i]); err != nil {
+				return fmt.Errorf("error inserting vector %d in the HNSW graph: %v", id, err)
+			}
+		}
+		if err := h.hnswStore(ctx, builder); err != nil {
+			return err
+		}
+
+		processed += len(ids)
+		lastID = ids[len(ids)-1]
+		log.Printf("HNSW progress: %d/%d (%v)", processed, total, time.Since(startTime).Truncate(time.Second))
+	}
+
+	log.Printf("HNSW processing completed in %s", time.Since(startTime))
+	return nil
+}
+
+// hnswStore writes the nodes added or changed by builder and its entry point
+// in one transaction, then hands the nodes over to the HNSW cache.
+func (h *DBHandler) hnswStore(ctx context.Context, builder *hnswBuilder) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	for id, node := range builder.nodes {
+		if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO vectors_hnsw (id, embedding, neighbors) VALUES (?, ?, ?)", id, Float32ToBytes(node.embedding), hnswNeighborsEncode(node.neighbors)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error inserting HNSW node %d: %v", id, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO setup (key, value) VALUES ('annEntry', ?)", strconv.FormatInt(builder.entry, 10)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error storing HNSW entry point: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing HNSW nodes: %v", err)
+	}
+
+	for id, node := range builder.nodes {
+		h.hnsw.Put(strconv.FormatInt(id, 10), node)
+	}
+	clear(builder.nodes)
+	return nil
+}
+
+// SearchHNSW returns the IDs of the limit vectors closest to query in the
+// HNSW graph, exploring Config.AiAnnEfSearch candidates. Nodes are read from
+// the database as the search reaches them and kept in an LRU cache, so the
+// upper layers and the neighbourhood of frequent queries stay in memory.
+func (h *DBHandler) SearchHNSW(ctx context.Context, query []float32, size int, limit int) ([]VectorDistance, error) {
+	start := time.Now()
+
+	value, err := h.SetupGet(ctx, "annEntry")
+	if err != nil {
+		return nil, fmt.Errorf("HNSW entry point not found: %w", err)
+	}
+	entry, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HNSW entry point: %v", err)
+	}
+	ef := h.config.AiAnnEfSearch
+	if ef <= 0 {
+		ef = hnswEfSearch
+	}
+
+	graph := hnswGraph{node: func(id int64) (*hnswNode, error) {
+		return h.hnswNode(ctx, id)
+	}}
+	results, err := graph.search(BytesToFloat32(ExtractMRL(query, size)), entry, limit, ef)
+	if err != nil {
+		return nil, err
+	}
+
+	searchStage(ctx, "semantic.ann", start)
+	log.Printf("Search HNSW time: %v", time.Since(start))
+	return results, nil
+}
+
+func (h *DBHandler) hnswNode(ctx context.Context, id int64) (*hnswNode, error) {
+	key := strconv.FormatInt(id, 10)
+	if node, found := h.hnsw.Get(key); found {
+		return node, nil
+	}
+
+	var embedding, neighbors []byte
+	if err := h.db.QueryRowContext(ctx, "SELECT embedding, neighbors FROM vectors_hnsw WHERE id = ?", id).Scan(&embedding, &neighbors); err != nil {
+		return nil, fmt.Errorf("error loading HNSW node %d: %w", id, err)
+	}
+	node := &hnswNode{embedding: BytesToFloat32(embedding)}
+	var err error
+	if node.neighbors, err = hnswNeighborsDecode(neighbors); err != nil {
+		return nil, err
+	}
+
+	h.hnsw.Put(key, node)
+	return node, nil
+}
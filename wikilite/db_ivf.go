@@ -0,0 +1,285 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ivfBatch     = 1000
+	annCodebooks = 2
+)
+
+// ProcessIVF rebuilds the inverted file index of the section vectors, reduced
+// to Config.AiAnnSize dimensions when set: k-means splits them in
+// Config.AiAnnLists lists, by default the square root of their number, and
+// every list is stored as one vectors_ann_chunks row, its centroid in setup.
+func (h *DBHandler) ProcessIVF(ctx context.Context) error {
+	size := h.config.AiAnnSize
+
+	var total int
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM vectors").Scan(&total); err != nil {
+		return fmt.Errorf("error counting vectors: %v", err)
+	}
+	if total == 0 {
+		log.Printf("No vectors to process")
+		return nil
+	}
+	lists := ivfLists(h.config.AiAnnLists, total)
+	startTime := time.Now()
+
+	log.Printf("Sampling vectors to train %d IVF lists with size %d...", lists, size)
+	sample, err := h.annSample(ctx, lists*ivfTrainPerList)
+	if err != nil {
+		return err
+	}
+
+	centroids := ivfTrain(sample, lists, ivfIterations, func(iteration int, moved int) {
+		log.Printf("IVF training iteration %d: %d/%d vectors moved (%v)", iteration, moved, len(sample), time.Since(startTime).Truncate(time.Second))
+	})
+
+	log.Printf("Assigning vectors to the IVF lists...")
+	members := make([][]int64, lists)
+	if err := h.annVectors(ctx, func(ids []int64, vectors [][]float32) error {
+		for i, list := range ivfAssign(centroids, vectors) {
+			members[list] = append(members[list], ids[i])
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, table := range []string{"vectors_ann_chunks", "vectors_ann_index"} {
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return fmt.Errorf("error clearing %s table: %v", table, err)
+		}
+	}
+	for list, ids := range members {
+		if len(ids) == 0 {
+			continue
+		}
+		if err := h.ivfListPut(ctx, list+1, ids, size); err != nil {
+			return err
+		}
+		if (list+1)%100 == 0 {
+			log.Printf("IVF progress: %d/%d lists (%v)", list+1, lists, time.Since(startTime).Truncate(time.Second))
+		}
+	}
+
+	if err := h.annCodebookPut(ctx, "annCentroids", centroids); err != nil {
+		return err
+	}
+	for key, value := range map[string]string{
+		"annMode":  "ivf",
+		"annSize":  strconv.Itoa(size),
+		"annLists": strconv.Itoa(lists),
+	} {
+		if err := h.SetupPut(ctx, key, value); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("IVF processing completed in %s", time.Since(startTime))
+	return nil
+}
+
+// annSample draws up to count of the reduced section vectors, with a fixed
+// seed so that the same vectors always give the same sample.
+func (h *DBHandler) annSample(ctx context.Context, count int) ([][]float32, error) {
+	random := rand.New(rand.NewSource(1))
+	var sample [][]float32
+	seen := 0
+	err := h.annVectors(ctx, func(ids []int64, vectors [][]float32) error {
+		for _, vector := range vectors {
+			seen++
+			if len(sample) < count {
+				sample = append(sample, vector)
+			} else if i := random.Intn(seen); i < count {
+				sample[i] = vector
+			}
+		}
+		return nil
+	})
+	return sample, err
+}
+
+// annVectors passes fn the reduced section vectors in batches, by id.
+func (h *DBHandler) annVectors(ctx context.Context, fn func(ids []int64, vectors [][]float32) error) error {
+	rows, err := h.db.QueryContext(ctx, "SELECT id, embedding FROM vectors ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("error loading vectors: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var vectors [][]float32
+	for rows.Next() {
+		var id int64
+		var embedding []byte
+		if err := rows.Scan(&id, &embedding); err != nil {
+			return fmt.Errorf("error scanning vector row: %v", err)
+		}
+		ids = append(ids, id)
+		vectors = append(vectors, BytesToFloat32(ExtractMRL(BytesToFloat32(embedding), h.config.AiAnnSize)))
+		if len(ids) == ivfBatch {
+			if err := fn(ids, vectors); err != nil {
+				return err
+			}
+			ids, vectors = nil, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating vectors: %v", err)
+	}
+	if len(ids) > 0 {
+		return fn(ids, vectors)
+	}
+	return nil
+}
+
+// ivfListPut stores the vectors of a list in the chunk of the same id.
+func (h *DBHandler) ivfListPut(ctx context.Context, chunkID int, ids []int64, size int) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var chunk []byte
+	position := 0
+	for start := 0; start < len(ids); start += ivfBatch {
+		batch := ids[start:min(start+ivfBatch, len(ids))]
+		placeholders := make([]string, len(batch))
+		args := make([]interface{}, len(batch))
+		for i, id := range batch {
+			placeholders[i] = "?"
+			args[i] = id
+		}
+		rows, err := tx.QueryContext(ctx, "SELECT id, embedding FROM vectors WHERE id IN ("+strings.Join(placeholders, ",")+")", args...)
+		if err != nil {
+			return fmt.Errorf("error querying vectors batch: %v", err)
+		}
+		for rows.Next() {
+			var id int64
+			var embedding []byte
+			if err := rows.Scan(&id, &embedding); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning vector row: %v", err)
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO vectors_ann_index (vectors_id, chunk_id, chunk_position) VALUES (?, ?, ?)", id, chunkID, position); err != nil {
+				rows.Close()
+				return fmt.Errorf("error inserting ANN index for vector %d: %v", id, err)
+			}
+			chunk = append(chunk, ExtractMRL(BytesToFloat32(embedding), size)...)
+			position++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating vector rows: %v", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO vectors_ann_chunks (id, chunk) VALUES (?, ?)", chunkID, chunk); err != nil {
+		return fmt.Errorf("error inserting ANN chunk: %v", err)
+	}
+	return tx.Commit()
+}
+
+// SearchIVF returns the limit vectors closest to query among the lists of the
+// Config.AiAnnProbe centroids closest to it.
+func (h *DBHandler) SearchIVF(ctx context.Context, query []float32, size int, limit int) ([]VectorDistance, error) {
+	start := time.Now()
+
+	value, err := h.SetupGet(ctx, "annLists")
+	if err != nil {
+		return nil, fmt.Errorf("IVF lists not found: %w", err)
+	}
+	centroids, err := h.annCodebook(ctx, "annCentroids", extractNumberFromString(value))
+	if err != nil {
+		return nil, err
+	}
+	query = BytesToFloat32(ExtractMRL(query, size))
+	if len(query) != len(centroids[0]) {
+		return nil, fmt.Errorf("vectors must have the same length")
+	}
+	probe := h.config.AiAnnProbe
+	if probe <= 0 {
+		probe = ivfProbe
+	}
+
+	nearest := &hnswHeap{max: true}
+	for i, centroid := range centroids {
+		heap.Push(nearest, VectorDistance{ID: int64(i + 1), Distance: hnswDistance(query, centroid)})
+		if nearest.Len() > probe {
+			heap.Pop(nearest)
+		}
+	}
+	chunkIDs := make([]string, len(nearest.items))
+	for i, list := range nearest.items {
+		chunkIDs[i] = strconv.FormatInt(list.ID, 10)
+	}
+
+	rows, err := h.db.QueryContext(ctx, "SELECT id, chunk FROM vectors_ann_chunks WHERE id IN ("+strings.Join(chunkIDs, ",")+")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topAnnResults, err := annScan(rows, len(query)*4, limit, func(vector []byte) float32 {
+		return hnswDistance(query, BytesToFloat32(vector))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	searchStage(ctx, "semantic.ann", start)
+	log.Printf("Search IVF time: %v", time.Since(start))
+	return topAnnResults, nil
+}
+
+// annCodebook returns the count vectors stored under key in setup, kept in
+// memory once read unless the caches are disabled, the IVF centroids and the
+// PQ codebooks being the annCodebooks ones.
+func (h *DBHandler) annCodebook(ctx context.Context, key string, count int) ([][]float32, error) {
+	if codebook, found := h.codebooks.Get(key); found {
+		return codebook, nil
+	}
+
+	var data []byte
+	if err := h.db.QueryRowContext(ctx, "SELECT value FROM setup WHERE key = ?", key).Scan(&data); err != nil {
+		return nil, fmt.Errorf("ANN codebook %s not found: %w", key, err)
+	}
+	if count <= 0 || len(data) == 0 || len(data)%(count*4) != 0 {
+		return nil, fmt.Errorf("invalid ANN codebook %s", key)
+	}
+
+	vectors := BytesToFloat32(data)
+	dim := len(vectors) / count
+	codebook := make([][]float32, count)
+	for i := range codebook {
+		codebook[i] = vectors[i*dim : (i+1)*dim]
+	}
+
+	h.codebooks.Put(key, codebook)
+	return codebook, nil
+}
+
+func (h *DBHandler) annCodebookPut(ctx context.Context, key string, codebook [][]float32) error {
+	var data []byte
+	for _, vector := range codebook {
+		data = append(data, Float32ToBytes(vector)...)
+	}
+	if _, err := h.db.ExecContext(ctx, "INSERT OR REPLACE INTO setup (key, value) VALUES (?, ?)", key, data); err != nil {
+		return fmt.Errorf("error storing ANN codebook %s: %v", key, err)
+	}
+	h.codebooks.Purge()
+	return nil
+}
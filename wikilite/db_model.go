@@ -0,0 +1,89 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+func (h *DBHandler) AiModelImport(ctx context.Context, path string) error {
+	return h.aiModelImport(ctx, "gguf", path)
+}
+
+func (h *DBHandler) AiRerankModelImport(ctx context.Context, path string) error {
+	return h.aiModelImport(ctx, "gguf_rerank", path)
+}
+
+func (h *DBHandler) AiChatModelImport(ctx context.Context, path string) error {
+	return h.aiModelImport(ctx, "gguf_chat", path)
+}
+
+func (h *DBHandler) aiModelImport(ctx context.Context, key string, path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("file does not exist: %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+
+	_, err = h.db.ExecContext(ctx, `INSERT OR REPLACE INTO setup (key, value) VALUES (?, ?)`, key, data)
+
+	if err != nil {
+		return fmt.Errorf("failed to import model into database: %v", err)
+	}
+
+	return nil
+}
+
+func (h *DBHandler) AiModelLoad(ctx context.Context) []byte {
+	return h.aiModelLoad(ctx, "gguf")
+}
+
+func (h *DBHandler) AiRerankModelLoad(ctx context.Context) []byte {
+	return h.aiModelLoad(ctx, "gguf_rerank")
+}
+
+func (h *DBHandler) AiChatModelLoad(ctx context.Context) []byte {
+	return h.aiModelLoad(ctx, "gguf_chat")
+}
+
+func (h *DBHandler) aiModelLoad(ctx context.Context, key string) []byte {
+	var data []byte
+
+	row := h.db.QueryRowContext(ctx, `SELECT value FROM setup WHERE key = ? LIMIT 1`, key)
+
+	err := row.Scan(&data)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// AiHasChatModel reports whether a chat model is stored, without reading it.
+func (h *DBHandler) AiHasChatModel(ctx context.Context) bool {
+	var exists int
+	err := h.db.QueryRowContext(ctx, "SELECT 1 FROM setup WHERE key = 'gguf_chat' LIMIT 1").Scan(&exists)
+	return err == nil
+}
+
+func (h *DBHandler) AiHasANN(ctx context.Context) bool {
+	table := "vectors_ann_index"
+	if h.config.AiAnnMode == "hnsw" {
+		table = "vectors_hnsw"
+	}
+	var id int
+	err := h.db.QueryRowContext(ctx, "SELECT id FROM "+table+" LIMIT 1").Scan(&id)
+	return err != sql.ErrNoRows
+}
+
+func (h *DBHandler) AiHasVectors(ctx context.Context) bool {
+	var id int
+	err := h.db.QueryRowContext(ctx, "SELECT id FROM vectors LIMIT 1").Scan(&id)
+	return err != sql.ErrNoRows
+}
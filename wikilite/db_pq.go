@@ -0,0 +1,91 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// pqCodebook returns the product quantization codebook stored in setup with
+// its number of sub-vectors. When missing and train is set, it is trained
+// first over a sample of the vectors reduced to Config.AiAnnSize dimensions,
+// split in Config.AiAnnSubvectors sub-vectors.
+func (h *DBHandler) pqCodebook(ctx context.Context, train bool) ([][]float32, int, error) {
+	if value, err := h.SetupGet(ctx, "annSubvectors"); err == nil && value != "" {
+		subvectors := extractNumberFromString(value)
+		codes, _ := h.SetupGet(ctx, "annCodes")
+		codebook, err := h.annCodebook(ctx, "annCodebooks", subvectors*extractNumberFromString(codes))
+		return codebook, subvectors, err
+	}
+	if !train {
+		return nil, 0, fmt.Errorf("PQ codebooks not found")
+	}
+
+	startTime := time.Now()
+	log.Printf("Sampling vectors to train the PQ codebooks with size %d...", h.config.AiAnnSize)
+	sample, err := h.annSample(ctx, pqTrainVectors)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(sample) == 0 {
+		return nil, 0, fmt.Errorf("no vectors to train the PQ codebooks")
+	}
+	subvectors, err := pqSubvectors(h.config.AiAnnSubvectors, len(sample[0]))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	codebook := pqTrain(sample, subvectors, func(subvector int) {
+		log.Printf("PQ training: %d/%d sub-vectors (%v)", subvector, subvectors, time.Since(startTime).Truncate(time.Second))
+	})
+	if err := h.annCodebookPut(ctx, "annCodebooks", codebook); err != nil {
+		return nil, 0, err
+	}
+	for key, value := range map[string]string{
+		"annSubvectors": strconv.Itoa(subvectors),
+		"annCodes":      strconv.Itoa(len(codebook) / subvectors),
+	} {
+		if err := h.SetupPut(ctx, key, value); err != nil {
+			return nil, 0, err
+		}
+	}
+	return codebook, subvectors, nil
+}
+
+// SearchPQ returns the limit vectors closest to query, comparing it with
+// their codes through a table of its distances to every centroid.
+func (h *DBHandler) SearchPQ(ctx context.Context, query []float32, size int, limit int) ([]VectorDistance, error) {
+	start := time.Now()
+
+	codebook, subvectors, err := h.pqCodebook(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	query = BytesToFloat32(ExtractMRL(query, size))
+	if len(query) != subvectors*len(codebook[0]) {
+		return nil, fmt.Errorf("vectors must have the same length")
+	}
+	table := pqTable(codebook, subvectors, query)
+	codes := len(codebook) / subvectors
+
+	rows, err := h.db.QueryContext(ctx, "SELECT id, chunk FROM vectors_ann_chunks")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topAnnResults, err := annScan(rows, subvectors, limit, func(code []byte) float32 {
+		return pqDistance(table, codes, code)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	searchStage(ctx, "semantic.ann", start)
+	log.Printf("Search PQ time: %v", time.Since(start))
+	return topAnnResults, nil
+}
@@ -1,8 +1,9 @@
 // Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
 
-package main
+package wikilite
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
@@ -10,57 +11,59 @@ import (
 	"time"
 )
 
-func (h *DBHandler) ProcessTitles() error {
-	_, err := h.db.Exec("INSERT INTO article_search(rowid, title) SELECT id, title FROM articles")
-	if err != nil {
-		return fmt.Errorf("error populating article_search table: %v", err)
+func (h *DBHandler) ProcessTitles(ctx context.Context) error {
+	title := "title"
+	if h.config.Tokenizer == TokenizerStem {
+		title = "wikilite_stem(?1, title)"
 	}
 
-	return nil
-}
-
-func (h *DBHandler) ProcessContents() error {
-	_, err := h.db.Exec("INSERT INTO section_search(rowid, title, content) SELECT id, title, content FROM sections")
+	_, err := h.db.ExecContext(ctx, "INSERT INTO article_search(rowid, title) SELECT id, "+title+" FROM articles", h.config.Language)
 	if err != nil {
-		return fmt.Errorf("error populating section_search table: %v", err)
+		return fmt.Errorf("error populating article_search table: %v", err)
 	}
 
 	return nil
 }
 
-func (h *DBHandler) ProcessVocabulary() error {
-	_, err := h.db.Exec("INSERT OR IGNORE INTO vocabulary SELECT term FROM article_search_vocabulary")
-	if err != nil {
-		return fmt.Errorf("error populating vocabulary table: %v", err)
+// ProcessContents indexes the sections, inflating the compressed ones.
+func (h *DBHandler) ProcessContents(ctx context.Context) error {
+	title := "title"
+	content := "CASE WHEN content IS NOT NULL THEN content WHEN content_flate IS NOT NULL THEN wikilite_inflate(content_flate) END"
+	if h.config.Tokenizer == TokenizerStem {
+		title = "wikilite_stem(?1, COALESCE(title, ''))"
+		content = "wikilite_stem(?1, COALESCE(" + content + ", ''))"
 	}
 
-	_, err = h.db.Exec("INSERT OR IGNORE INTO vocabulary SELECT term FROM section_search_vocabulary")
+	_, err := h.db.ExecContext(ctx, "INSERT INTO section_search(rowid, title, content) SELECT id, "+title+", "+content+" FROM sections", h.config.Language)
 	if err != nil {
-		return fmt.Errorf("error populating vocabulary table: %v", err)
+		return fmt.Errorf("error populating section_search table: %v", err)
 	}
 
 	return nil
 }
 
-func (h *DBHandler) ProcessEmbeddings() (err error) {
+func (h *DBHandler) ProcessEmbeddings(ctx context.Context) (err error) {
+	if h.vectorsDropped(ctx) {
+		return ErrVectorsDropped
+	}
 	batchSize := 250
 
-	if options.aiModel != "" {
-		if err = db.SetupPut("model", options.aiModel); err != nil {
+	if h.config.AiModel != "" {
+		if err = h.SetupPut(ctx, "model", h.config.AiModel); err != nil {
 			return
 		}
 	}
 
-	if err = db.SetupPut("modelPrefixSave", options.aiModelPrefixSave); err != nil {
+	if err = h.SetupPut(ctx, "modelPrefixSave", h.config.AiModelPrefixSave); err != nil {
 		return
 	}
 
-	if err = db.SetupPut("modelPrefixSearch", options.aiModelPrefixSearch); err != nil {
+	if err = h.SetupPut(ctx, "modelPrefixSearch", h.config.AiModelPrefixSearch); err != nil {
 		return
 	}
 
 	log.Printf("Loading pending vector IDs for Embeddings processing...")
-	rows, err := h.db.Query(`
+	rows, err := h.db.QueryContext(ctx, `
 		SELECT s.id 
 		FROM sections s 
 		WHERE s.id NOT IN (SELECT id FROM vectors)
@@ -104,7 +107,7 @@ func (h *DBHandler) ProcessEmbeddings() (err error) {
 			break
 		}
 
-		tx, err := h.db.Begin()
+		tx, err := h.db.BeginTx(ctx, nil)
 		if err != nil {
 			return fmt.Errorf("error starting transaction: %w", err)
 		}
@@ -122,7 +125,7 @@ func (h *DBHandler) ProcessEmbeddings() (err error) {
 			JOIN articles a ON s.article_id = a.id 
 			WHERE s.id IN (%s)`, strings.Join(placeholders, ","))
 
-		rows, err := tx.Query(query, args...)
+		rows, err := tx.QueryContext(ctx, query, args...)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("error querying sections batch: %w", err)
@@ -156,14 +159,14 @@ func (h *DBHandler) ProcessEmbeddings() (err error) {
 		for i, sectionID := range sectionIDs {
 			fullSectionText := articleTitles[i] + " - " + sectionTitles[i] + "\n\n" + sectionContents[i]
 
-			embedding, err := aiEmbeddings(options.aiModelPrefixSave + fullSectionText)
+			embedding, err := h.ai.Embeddings(ctx, h.config.AiModelPrefixSave+fullSectionText)
 			if err != nil {
 				log.Printf("Embedding generation error for section %d: %v", sectionID, err)
 				problematicIDs = append(problematicIDs, sectionID)
 				continue
 			}
 
-			if _, err := tx.Exec("INSERT OR REPLACE INTO vectors (id, embedding) VALUES (?, ?)", sectionID, Float32ToBytes(embedding)); err != nil {
+			if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO vectors (id, embedding) VALUES (?, ?)", sectionID, Float32ToBytes(embedding)); err != nil {
 				log.Printf("Error inserting vector for section %d: %v", sectionID, err)
 				problematicIDs = append(problematicIDs, sectionID)
 				continue
@@ -190,24 +193,40 @@ func (h *DBHandler) ProcessEmbeddings() (err error) {
 		log.Printf("Embedding process completed with %d problematic sections that need manual review", len(problematicIDs))
 	}
 
-	if options.aiAnn {
-		return h.ProcessANN()
+	if h.config.AiAnn {
+		return h.ProcessANN(ctx)
 	}
 
 	return nil
 }
 
-func (h *DBHandler) ProcessANN() error {
+// ProcessANN indexes the vectors missing from the ANN index of
+// Config.AiAnnMode, dropping first an index built with another mode, size or
+// number of PQ sub-vectors.
+func (h *DBHandler) ProcessANN(ctx context.Context) error {
+	if h.vectorsDropped(ctx) {
+		return ErrVectorsDropped
+	}
+	if err := h.annReset(ctx); err != nil {
+		return err
+	}
+	if h.config.AiAnnMode == "hnsw" {
+		return h.ProcessHNSW(ctx)
+	}
+	if h.config.AiAnnMode == "ivf" {
+		return h.ProcessIVF(ctx)
+	}
+
 	batchSize := 250
 	method := ""
 	size := 0
-	if options.aiAnnMode == "mrl" || options.aiAnnMode == "binary" {
-		method = options.aiAnnMode
-		size = options.aiAnnSize
-		if err := db.SetupPut("annMode", method); err != nil {
+	if h.config.AiAnnMode == "mrl" || h.config.AiAnnMode == "binary" || h.config.AiAnnMode == "pq" {
+		method = h.config.AiAnnMode
+		size = h.config.AiAnnSize
+		if err := h.SetupPut(ctx, "annMode", method); err != nil {
 			return err
 		}
-		if err := db.SetupPut("annSize", fmt.Sprintf("%d", size)); err != nil {
+		if err := h.SetupPut(ctx, "annSize", fmt.Sprintf("%d", size)); err != nil {
 			return err
 		}
 	}
@@ -219,9 +238,18 @@ func (h *DBHandler) ProcessANN() error {
 		return fmt.Errorf("invalid quantization size")
 	}
 
+	var codebook [][]float32
+	var subvectors int
+	if method == "pq" {
+		var err error
+		if codebook, subvectors, err = h.pqCodebook(ctx, true); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("Loading pending vector IDs for ANN processing using mode %s and size %d...", method, size)
 
-	rows, err := h.db.Query(`
+	rows, err := h.db.QueryContext(ctx, `
         SELECT v.id 
         FROM vectors v 
         WHERE v.id NOT IN (SELECT vectors_id FROM vectors_ann_index)
@@ -267,7 +295,7 @@ func (h *DBHandler) ProcessANN() error {
 			break
 		}
 
-		tx, err := h.db.Begin()
+		tx, err := h.db.BeginTx(ctx, nil)
 		if err != nil {
 			return fmt.Errorf("error starting transaction: %w", err)
 		}
@@ -280,7 +308,7 @@ func (h *DBHandler) ProcessANN() error {
 		}
 
 		query := fmt.Sprintf("SELECT id, embedding FROM vectors WHERE id IN (%s)", strings.Join(placeholders, ","))
-		rows, err := tx.Query(query, args...)
+		rows, err := tx.QueryContext(ctx, query, args...)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("error querying vectors batch: %w", err)
@@ -308,7 +336,7 @@ func (h *DBHandler) ProcessANN() error {
 		}
 
 		var annChunkID int
-		if err := tx.QueryRow("SELECT COALESCE(MAX(id), 0) + 1 FROM vectors_ann_chunks").Scan(&annChunkID); err != nil {
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) + 1 FROM vectors_ann_chunks").Scan(&annChunkID); err != nil {
 			tx.Rollback()
 			return fmt.Errorf("error getting next chunk ID: %w", err)
 		}
@@ -322,9 +350,11 @@ func (h *DBHandler) ProcessANN() error {
 				annData = ExtractMRL(embedding, size)
 			} else if method == "binary" {
 				annData = QuantizeBinary(embedding)
+			} else if method == "pq" {
+				annData = pqEncode(codebook, subvectors, BytesToFloat32(ExtractMRL(embedding, size)))
 			}
 
-			if _, err := tx.Exec(
+			if _, err := tx.ExecContext(ctx,
 				"INSERT INTO vectors_ann_index (vectors_id, chunk_id, chunk_position) VALUES (?, ?, ?)",
 				vectorID, annChunkID, i); err != nil {
 				tx.Rollback()
@@ -334,7 +364,7 @@ func (h *DBHandler) ProcessANN() error {
 			annChunkData = append(annChunkData, annData...)
 		}
 
-		if _, err := tx.Exec(
+		if _, err := tx.ExecContext(ctx,
 			"INSERT INTO vectors_ann_chunks (id, chunk) VALUES (?, ?)",
 			annChunkID, annChunkData); err != nil {
 			tx.Rollback()
@@ -360,3 +390,24 @@ func (h *DBHandler) ProcessANN() error {
 	log.Printf("ANN processing completed in %s", time.Since(startTime))
 	return nil
 }
+
+func (h *DBHandler) annReset(ctx context.Context) error {
+	mode, _ := h.SetupGet(ctx, "annMode")
+	size, _ := h.SetupGet(ctx, "annSize")
+	subvectors, _ := h.SetupGet(ctx, "annSubvectors")
+	if mode == "" || (mode == h.config.AiAnnMode && extractNumberFromString(size) == h.config.AiAnnSize &&
+		(mode != "pq" || h.config.AiAnnSubvectors <= 0 || extractNumberFromString(subvectors) == h.config.AiAnnSubvectors)) {
+		return nil
+	}
+
+	log.Printf("Dropping the ANN index of mode %s and size %s", mode, size)
+	for _, table := range []string{"vectors_ann_chunks", "vectors_ann_index", "vectors_hnsw"} {
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return fmt.Errorf("error clearing %s table: %v", table, err)
+		}
+	}
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM setup WHERE key IN ('annCentroids', 'annCodebooks', 'annSubvectors', 'annCodes')"); err != nil {
+		return fmt.Errorf("error clearing ANN codebooks: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	relatedBatch      = 1000
+	relatedTop        = 20
+	relatedCandidates = 200
+	relatedTerms      = 10
+	relatedWords      = 1000
+	relatedSections   = 4
+)
+
+// ProcessRelated rebuilds the article embeddings, the normalized mean of the
+// section vectors of every article, and stores the relatedTop articles
+// closest to every one of them, served by ArticleRelated.
+func (h *DBHandler) ProcessRelated(ctx context.Context) error {
+	if h.vectorsDropped(ctx) {
+		return ErrVectorsDropped
+	}
+	if !h.AiHasVectors(ctx) {
+		return fmt.Errorf("no section embeddings available")
+	}
+	if err := h.relatedVectors(ctx); err != nil {
+		return err
+	}
+	return h.relatedNeighbors(ctx)
+}
+
+func (h *DBHandler) relatedVectors(ctx context.Context) error {
+	rows, err := h.db.QueryContext(ctx, "SELECT DISTINCT article_id FROM sections WHERE id IN (SELECT id FROM vectors) ORDER BY article_id")
+	if err != nil {
+		return fmt.Errorf("error loading article IDs: %v", err)
+	}
+	var articleIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning article ID: %v", err)
+		}
+		articleIDs = append(articleIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating article IDs: %v", err)
+	}
+
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM article_vectors"); err != nil {
+		return fmt.Errorf("error clearing article_vectors table: %v", err)
+	}
+
+	startTime := time.Now()
+	for processed := 0; processed < len(articleIDs); processed += relatedBatch {
+		batch := articleIDs[processed:min(processed+relatedBatch, len(articleIDs))]
+
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT s.article_id, v.embedding
+			FROM vectors v
+			JOIN sections s ON s.id = v.id
+			WHERE s.article_id BETWEEN ? AND ?`, batch[0], batch[len(batch)-1])
+		if err != nil {
+			return fmt.Errorf("error querying vectors batch: %v", err)
+		}
+		sums := make(map[int][]float32)
+		for rows.Next() {
+			var articleID int
+			var embeddingBlob []byte
+			if err := rows.Scan(&articleID, &embeddingBlob); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning vector row: %v", err)
+			}
+			embedding := NormalizeVectors([][]float32{BytesToFloat32(embeddingBlob)})[0]
+			if sums[articleID] == nil {
+				sums[articleID] = embedding
+				continue
+			}
+			if len(embedding) != len(sums[articleID]) {
+				rows.Close()
+				return fmt.Errorf("section vectors of article %d have different dimensions", articleID)
+			}
+			for i, value := range embedding {
+				sums[articleID][i] += value
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating vector rows: %v", err)
+		}
+
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %v", err)
+		}
+		for _, articleID := range batch {
+			embedding := NormalizeVectors([][]float32{sums[articleID]})[0]
+			if _, err := tx.ExecContext(ctx, "INSERT INTO article_vectors (id, embedding) VALUES (?, ?)", articleID, Float32ToBytes(embedding)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("error inserting article vector: %v", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing article vectors: %v", err)
+		}
+
+		log.Printf("Article embedding progress: %d/%d (%v)", processed+len(batch), len(articleIDs), time.Since(startTime).Truncate(time.Second))
+	}
+
+	return nil
+}
+
+// relatedNeighbors stores the relatedTop articles closest to every article.
+// With an hnsw or ivf index the candidates are the articles of the
+// relatedCandidates sections it finds closest to the article embedding,
+// otherwise every batch of articles is compared with all the others.
+func (h *DBHandler) relatedNeighbors(ctx context.Context) error {
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM article_related"); err != nil {
+		return fmt.Errorf("error clearing article_related table: %v", err)
+	}
+
+	var total int
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM article_vectors").Scan(&total); err != nil {
+		return fmt.Errorf("error counting article vectors: %v", err)
+	}
+	ann := (h.config.AiAnnMode == "hnsw" || h.config.AiAnnMode == "ivf") && h.AiHasANN(ctx)
+	if !ann {
+		log.Printf("Comparing every article with all the others, an hnsw or ivf index makes it faster")
+	}
+
+	startTime := time.Now()
+	var lastID int64
+	for processed := 0; processed < total; {
+		ids, embeddings, err := h.relatedBatch(ctx, lastID)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		var top [][]VectorDistance
+		if ann {
+			top, err = h.relatedAnn(ctx, ids, embeddings)
+		} else {
+			top, err = h.relatedScan(ctx, ids, embeddings)
+		}
+		if err != nil {
+			return err
+		}
+
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %v", err)
+		}
+		for i, id := range ids {
+			for _, vd := range top[i] {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO article_related (article_id, related_id, distance) VALUES (?, ?, ?)", id, vd.ID, vd.Distance); err != nil {
+					tx.Rollback()
+					return fmt.Errorf("error inserting related article: %v", err)
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing related articles: %v", err)
+		}
+
+		processed += len(ids)
+		lastID = ids[len(ids)-1]
+		log.Printf("Related articles progress: %d/%d (%v)", processed, total, time.Since(startTime).Truncate(time.Second))
+	}
+
+	return nil
+}
+
+// relatedBatch loads the next relatedBatch article embeddings after lastID.
+func (h *DBHandler) relatedBatch(ctx context.Context, lastID int64) ([]int64, [][]float32, error) {
+	rows, err := h.db.QueryContext(ctx, "SELECT id, embedding FROM article_vectors WHERE id > ? ORDER BY id LIMIT ?", lastID, relatedBatch)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error querying article vectors: %v", err)
+	}
+	defer rows.Close()
+	var ids []int64
+	var embeddings [][]float32
+	for rows.Next() {
+		var id int64
+		var embeddingBlob []byte
+		if err := rows.Scan(&id, &embeddingBlob); err != nil {
+			return nil, nil, fmt.Errorf("error scanning article vector: %v", err)
+		}
+		ids = append(ids, id)
+		embeddings = append(embeddings, BytesToFloat32(embeddingBlob))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating article vectors: %v", err)
+	}
+	return ids, embeddings, nil
+}
+
+// relatedScan compares the batch with every article embedding in one pass.
+func (h *DBHandler) relatedScan(ctx context.Context, ids []int64, embeddings [][]float32) ([][]VectorDistance, error) {
+	rows, err := h.db.QueryContext(ctx, "SELECT id, embedding FROM article_vectors")
+	if err != nil {
+		return nil, fmt.Errorf("error querying article vectors: %v", err)
+	}
+	defer rows.Close()
+
+	top := make([][]VectorDistance, len(ids))
+	for rows.Next() {
+		var id int64
+		var embeddingBlob []byte
+		if err := rows.Scan(&id, &embeddingBlob); err != nil {
+			return nil, fmt.Errorf("error scanning article vector: %v", err)
+		}
+		embedding := BytesToFloat32(embeddingBlob)
+		for i, articleID := range ids {
+			if id == articleID {
+				continue
+			}
+			distance, err := EuclideanDistance(embeddings[i], embedding)
+			if err != nil {
+				return nil, err
+			}
+			top[i] = relatedInsert(top[i], VectorDistance{ID: id, Distance: distance})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating article vectors: %v", err)
+	}
+	return top, nil
+}
+
+// relatedAnn ranks, by their article embeddings, the articles of the sections
+// the ANN index finds closest to every article of the batch.
+func (h *DBHandler) relatedAnn(ctx context.Context, ids []int64, embeddings [][]float32) ([][]VectorDistance, error) {
+	top := make([][]VectorDistance, len(ids))
+	for i, articleID := range ids {
+		results, err := h.SearchAnn(ctx, embeddings[i], h.config.AiAnnMode, h.config.AiAnnSize, relatedCandidates)
+		if err != nil {
+			return nil, fmt.Errorf("error searching the ANN index: %v", err)
+		}
+		var sections []string
+		for _, v := range results {
+			id, err := h.annID(ctx, v)
+			if err == sql.ErrNoRows {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("error looking up ANN result: %v", err)
+			}
+			sections = append(sections, strconv.FormatInt(id, 10))
+		}
+		if len(sections) == 0 {
+			continue
+		}
+
+		rows, err := h.db.QueryContext(ctx, "SELECT v.id, v.embedding FROM article_vectors v WHERE v.id != ? AND v.id IN (SELECT s.article_id FROM sections s WHERE s.id IN ("+strings.Join(sections, ",")+"))", articleID)
+		if err != nil {
+			return nil, fmt.Errorf("error querying candidate articles: %v", err)
+		}
+		for rows.Next() {
+			var id int64
+			var embeddingBlob []byte
+			if err := rows.Scan(&id, &embeddingBlob); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning candidate article: %v", err)
+			}
+			distance, err := EuclideanDistance(embeddings[i], BytesToFloat32(embeddingBlob))
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			top[i] = relatedInsert(top[i], VectorDistance{ID: id, Distance: distance})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating candidate articles: %v", err)
+		}
+	}
+	return top, nil
+}
+
+// relatedInsert adds vd to top, sorted by distance, keeping the relatedTop
+// closest.
+func relatedInsert(top []VectorDistance, vd VectorDistance) []VectorDistance {
+	if len(top) == relatedTop && vd.Distance >= top[relatedTop-1].Distance {
+		return top
+	}
+	i := sort.Search(len(top), func(i int) bool { return top[i].Distance > vd.Distance })
+	top = slices.Insert(top, i, vd)
+	if len(top) > relatedTop {
+		top = top[:relatedTop]
+	}
+	return top
+}
+
+// ArticleRelated returns the limit articles closest to articleID, up to
+// relatedTop, by the Euclidean distance of their embeddings, stored as Power,
+// when ProcessRelated has stored them, or else by a lexical search of the most
+// distinctive words of the article, the best bm25 of their sections as Power.
+// Text is the beginning of the related article or, for lexical matches, the
+// excerpt of the matching section.
+func (h *DBHandler) ArticleRelated(ctx context.Context, articleID int, limit int) ([]SearchResult, error) {
+	start := time.Now()
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT r.related_id, r.distance, a.title, COALESCE((SELECT MIN(s.id) FROM sections s WHERE s.article_id = a.id), 0)
+		FROM article_related r
+		JOIN articles a ON a.id = r.related_id
+		WHERE r.article_id = ?
+		ORDER BY r.distance
+		LIMIT ?`, articleID, limit)
+	if err != nil {
+		return nil, err
+	}
+	var results []SearchResult
+	var sectionIDs []int
+	for rows.Next() {
+		result := SearchResult{Type: "V"}
+		var sectionID int
+		if err := rows.Scan(&result.ArticleID, &result.Power, &result.Title, &sectionID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results = append(results, result)
+		sectionIDs = append(sectionIDs, sectionID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return h.articleRelatedLexical(ctx, articleID, limit)
+	}
+
+	for i, sectionID := range sectionIDs {
+		if sectionID == 0 {
+			continue
+		}
+		content, err := h.SectionContent(ctx, sectionID)
+		if err != nil {
+			return nil, err
+		}
+		snippetApply(&results[i], snippetSelect(content, "", snippetTokens, nil))
+	}
+
+	log.Printf("Article related vectors: %d (%v)", articleID, time.Since(start))
+
+	return results, nil
+}
+
+// articleRelatedLexical searches the relatedTerms words of the first
+// relatedWords of the article that are frequent in it and rare in the
+// vocabulary, by tf-idf with the vocabulary frequency standing for the
+// document one, as any of them, keeping the best section of every article.
+func (h *DBHandler) articleRelatedLexical(ctx context.Context, articleID int, limit int) ([]SearchResult, error) {
+	start := time.Now()
+
+	rows, err := h.db.QueryContext(ctx, "SELECT id FROM sections WHERE article_id = ? ORDER BY id", articleID)
+	if err != nil {
+		return nil, err
+	}
+	var sectionIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sectionIDs = append(sectionIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stem := h.snippetStemmer()
+	counts := make(map[string]int)
+	surfaces := make(map[string]string)
+	words := 0
+	for _, sectionID := range sectionIDs {
+		if words >= relatedWords {
+			break
+		}
+		content, err := h.SectionContent(ctx, sectionID)
+		if err != nil {
+			return nil, err
+		}
+		for _, word := range strings.Fields(content) {
+			if words >= relatedWords {
+				break
+			}
+			words++
+			surface := snippetWord(word, nil)
+			if len([]rune(surface)) < vocabularyVectorsMin || !vocabularyIndexable(surface) || strings.ContainsFunc(surface, func(r rune) bool { return !snippetIsWord(r) }) {
+				continue
+			}
+			term := snippetWord(word, stem)
+			counts[term]++
+			if surfaces[term] == "" {
+				surfaces[term] = surface
+			}
+		}
+	}
+
+	type relatedTerm struct {
+		word   string
+		weight float64
+	}
+	var sections int64
+	if err := h.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM sections").Scan(&sections); err != nil {
+		return nil, err
+	}
+	frequencies, err := h.vocabularyFrequencies(ctx, slices.Collect(maps.Keys(counts)))
+	if err != nil {
+		return nil, err
+	}
+	var terms []relatedTerm
+	for term, count := range counts {
+		idf := math.Log(1 + float64(sections)/float64(1+frequencies[term]))
+		terms = append(terms, relatedTerm{surfaces[term], (1 + math.Log(float64(count))) * idf})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].weight != terms[j].weight {
+			return terms[i].weight > terms[j].weight
+		}
+		return terms[i].word < terms[j].word
+	})
+	if len(terms) > relatedTerms {
+		terms = terms[:relatedTerms]
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var query []string
+	for _, term := range terms {
+		query = append(query, term.word)
+	}
+	matches, err := h.SearchContent(ctx, strings.Join(query, " OR "), (limit+1)*relatedSections, 0, SearchFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	seen := map[int]bool{articleID: true}
+	for _, match := range matches {
+		if seen[match.ArticleID] || len(results) >= limit {
+			continue
+		}
+		seen[match.ArticleID] = true
+		match.explain = nil
+		results = append(results, match)
+	}
+
+	log.Printf("Article related lexical: %d %v (%v)", articleID, query, time.Since(start))
+
+	return results, nil
+}
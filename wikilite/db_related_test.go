@@ -0,0 +1,72 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"testing"
+)
+
+func TestArticleRelated(t *testing.T) {
+	ctx := context.Background()
+	embeddings := [][]float32{{0, 1}, {0.1, 1}, {1, 0}, {1, 0.1}, {0.6, 0.8}}
+	want := map[int][]int{
+		1: {2, 5, 4},
+		3: {4, 5, 2},
+		5: {2, 1, 4},
+	}
+
+	for _, mode := range []string{"", "hnsw"} {
+		e := testOpenVectors(t, Config{AiAnnMode: mode}, embeddings)
+		if mode == "hnsw" {
+			if err := e.db.write(func() error { return e.db.ProcessHNSW(ctx) }); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.ProcessRelated(ctx); err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+
+		for articleID, ids := range want {
+			results, err := e.ArticleRelated(ctx, articleID, len(ids))
+			if err != nil {
+				t.Fatalf("%q: %v", mode, err)
+			}
+			var got []int
+			for i, result := range results {
+				got = append(got, result.ArticleID)
+				if result.Type != "V" || result.Text == "" {
+					t.Errorf("%q: article %d: result %+v", mode, articleID, result)
+				}
+				if i > 0 && result.Power < results[i-1].Power {
+					t.Errorf("%q: article %d: results not sorted by distance", mode, articleID)
+				}
+			}
+			if len(got) != len(ids) {
+				t.Errorf("%q: article %d: related %v, want %v", mode, articleID, got, ids)
+				continue
+			}
+			for i := range ids {
+				if got[i] != ids[i] {
+					t.Errorf("%q: article %d: related %v, want %v", mode, articleID, got, ids)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestRelatedInsert(t *testing.T) {
+	var top []VectorDistance
+	for i := relatedTop * 2; i > 0; i-- {
+		top = relatedInsert(top, VectorDistance{ID: int64(i), Distance: float32(i)})
+	}
+	if len(top) != relatedTop {
+		t.Fatalf("kept %d, want %d", len(top), relatedTop)
+	}
+	for i, vd := range top {
+		if vd.ID != int64(i+1) {
+			t.Fatalf("position %d: %d, want %d", i, vd.ID, i+1)
+		}
+	}
+}
@@ -0,0 +1,645 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (h *DBHandler) SearchTitle(ctx context.Context, searchQuery string, limit int, snippet int, filter SearchFilter) ([]SearchResult, error) {
+	start := time.Now()
+	stem := h.stemmer()
+	match := queryFTS(searchQuery, stem, nil, []string{"title"})
+	if match == "" {
+		return nil, nil
+	}
+	filterSQL, filterArgs := searchFilterSQL(filter, "a.id", "")
+
+	sqlQuery := `
+		SELECT a.id, a.title, bm25(article_search) AS power
+		FROM article_search
+		JOIN articles a ON article_search.rowid = a.id
+		WHERE article_search MATCH ?` + filterSQL + `
+		ORDER BY power ASC
+		LIMIT ?
+	`
+
+	args := append(append([]interface{}{match}, filterArgs...), limit)
+	rows, err := h.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(
+			&result.ArticleID,
+			&result.Title,
+			&result.Power,
+		); err != nil {
+			return nil, err
+		}
+		result.Type = "T"
+		result.explain = &SearchExplain{Table: "article_search"}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		var sectionID int
+		err = h.db.QueryRowContext(ctx, `SELECT id FROM sections WHERE article_id = ? ORDER BY id LIMIT 1`, results[i].ArticleID).Scan(&sectionID)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		content, err := h.SectionContent(ctx, sectionID)
+		if err != nil {
+			return nil, err
+		}
+		snippetApply(&results[i], snippetSelect(content, searchQuery, snippetLength(snippet), h.snippetStemmer()))
+	}
+
+	log.Printf("Search title: %s (%v)", match, time.Since(start))
+
+	return results, nil
+}
+
+// SearchContent matches sections, the excerpt comes from FTS5 snippet(), or
+// highlight() for the full text, falling back to snippetSelect on compressed
+// sections and on indexes stemmed in Go, which hold no text.
+func (h *DBHandler) SearchContent(ctx context.Context, searchQuery string, limit int, snippet int, filter SearchFilter) ([]SearchResult, error) {
+	return h.SearchContentExpanded(ctx, searchQuery, nil, limit, snippet, filter)
+}
+
+// SearchContentExpanded is SearchContent with the query words found in
+// expansions also matching the index terms listed there, as returned by
+// VocabularyNeighbors.
+func (h *DBHandler) SearchContentExpanded(ctx context.Context, searchQuery string, expansions map[string][]string, limit int, snippet int, filter SearchFilter) ([]SearchResult, error) {
+	start := time.Now()
+	stem := h.stemmer()
+	match := queryFTS(searchQuery, stem, expansions, []string{"title", "content"})
+	if match == "" {
+		return nil, nil
+	}
+	filterSQL, filterArgs := searchFilterSQL(filter, "s.article_id", "s")
+
+	length := snippetLength(snippet)
+	excerpt := "snippet(section_search, 1, char(2), char(3), '" + snippetEllipsis + "', ?)"
+	if stem != nil {
+		excerpt = "NULL"
+	} else if length < 0 {
+		excerpt = "highlight(section_search, 1, char(2), char(3))"
+	}
+
+	sqlQuery := `
+		SELECT
+			s.article_id,
+			a.title,
+			s.id,
+			COALESCE(s.title, ''),
+			s.content IS NULL,
+			` + excerpt + `,
+			bm25(section_search) as power
+		FROM section_search
+		JOIN sections s ON section_search.rowid = s.id
+		JOIN articles a ON s.article_id = a.id
+		WHERE section_search MATCH ?` + filterSQL + `
+		ORDER BY power
+		LIMIT ?;
+	`
+	args := append(append([]interface{}{match}, filterArgs...), limit)
+	if stem == nil && length >= 0 {
+		args = append([]interface{}{length}, args...)
+	}
+	rows, err := h.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	compressed := make(map[int]int)
+	for rows.Next() {
+		var result SearchResult
+		var isCompressed bool
+		var marked sql.NullString
+		if err := rows.Scan(&result.ArticleID, &result.Title, &result.SectionID, &result.SectionTitle, &isCompressed, &marked, &result.Power); err != nil {
+			return nil, err
+		}
+		if isCompressed || stem != nil {
+			compressed[len(results)] = result.SectionID
+		} else {
+			snippetApply(&result, marked.String)
+		}
+		result.Type = "C"
+		result.explain = &SearchExplain{Table: "section_search", SectionID: result.SectionID}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	snippetQuery := searchQuery
+	for _, alternatives := range expansions {
+		snippetQuery += " " + strings.Join(alternatives, " ")
+	}
+	for i, sectionID := range compressed {
+		content, err := h.SectionContent(ctx, sectionID)
+		if err != nil {
+			return nil, err
+		}
+		snippetApply(&results[i], snippetSelect(content, snippetQuery, length, h.snippetStemmer()))
+	}
+
+	log.Printf("Search content: %s (%v)", match, time.Since(start))
+
+	return results, nil
+}
+
+// SearchCount returns the number of articles matching searchQuery in the
+// title or content rankings allowed by filter, stopping at limit when it is
+// above 0.
+func (h *DBHandler) SearchCount(ctx context.Context, searchQuery string, filter SearchFilter, limit int) (count int, err error) {
+	stem := h.stemmer()
+	titleMatch := queryFTS(searchQuery, stem, nil, []string{"title"})
+	contentMatch := queryFTS(searchQuery, stem, nil, []string{"title", "content"})
+	if titleMatch == "" || contentMatch == "" {
+		return 0, nil
+	}
+
+	var parts []string
+	var args []interface{}
+	if filter.allows(SearchRetrieverTitle) {
+		filterSQL, filterArgs := searchFilterSQL(filter, "rowid", "")
+		parts = append(parts, "SELECT rowid FROM article_search WHERE article_search MATCH ?"+filterSQL)
+		args = append(append(args, titleMatch), filterArgs...)
+	}
+	if filter.allows(SearchRetrieverContent) {
+		filterSQL, filterArgs := searchFilterSQL(filter, "s.article_id", "s")
+		parts = append(parts, "SELECT s.article_id FROM section_search JOIN sections s ON section_search.rowid = s.id WHERE section_search MATCH ?"+filterSQL)
+		args = append(append(args, contentMatch), filterArgs...)
+	}
+	if len(parts) == 0 {
+		return 0, nil
+	}
+
+	union := strings.Join(parts, " UNION ")
+	if limit > 0 {
+		union += " LIMIT ?"
+		args = append(args, limit)
+	}
+	err = h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+union+")", args...).Scan(&count)
+	return
+}
+
+// SearchFacets counts the sections matching searchQuery by heading and by
+// heading level, the limit most frequent values of each.
+func (h *DBHandler) SearchFacets(ctx context.Context, searchQuery string, filter SearchFilter, limit int) (map[string][]SearchFacet, error) {
+	facets := make(map[string][]SearchFacet)
+	match := queryFTS(searchQuery, h.stemmer(), nil, []string{"title", "content"})
+	if match == "" || !filter.allows(SearchRetrieverContent) {
+		return facets, nil
+	}
+	filterSQL, filterArgs := searchFilterSQL(filter, "s.article_id", "s")
+
+	queries := []struct {
+		name  string
+		query string
+	}{
+		{"section", "SELECT COALESCE(s.title, ''), COUNT(*) AS count FROM section_search JOIN sections s ON section_search.rowid = s.id WHERE section_search MATCH ?" + filterSQL + " GROUP BY 1 ORDER BY count DESC, 1 LIMIT ?"},
+		{"level", "SELECT s.pow, COUNT(*) AS count FROM section_search JOIN sections s ON section_search.rowid = s.id WHERE section_search MATCH ?" + filterSQL + " GROUP BY 1 ORDER BY count DESC, 1 LIMIT ?"},
+	}
+	for _, facet := range queries {
+		rows, err := h.db.QueryContext(ctx, facet.query, append(append([]interface{}{match}, filterArgs...), limit)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var value SearchFacet
+			if err := rows.Scan(&value.Value, &value.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			facets[facet.name] = append(facets[facet.name], value)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return facets, nil
+}
+
+// searchFilterSQL returns the conditions of filter, to append to a WHERE
+// clause, on the given article id column and sections table alias. Section
+// conditions are left out without an alias.
+func searchFilterSQL(filter SearchFilter, articleColumn string, sectionAlias string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if len(filter.ArticleIDs) > 0 {
+		conditions = append(conditions, articleColumn+" IN (?"+strings.Repeat(", ?", len(filter.ArticleIDs)-1)+")")
+		for _, id := range filter.ArticleIDs {
+			args = append(args, id)
+		}
+	}
+	if sectionAlias != "" {
+		if len(filter.Sections) > 0 {
+			conditions = append(conditions, "COALESCE("+sectionAlias+".title, '') IN (?"+strings.Repeat(", ?", len(filter.Sections)-1)+")")
+			for _, title := range filter.Sections {
+				args = append(args, title)
+			}
+		}
+		if filter.LevelMin > 0 {
+			conditions = append(conditions, sectionAlias+".pow >= ?")
+			args = append(args, filter.LevelMin)
+		}
+		if filter.LevelMax > 0 {
+			conditions = append(conditions, sectionAlias+".pow <= ?")
+			args = append(args, filter.LevelMax)
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
+
+// allows tells whether the filter keeps the named retriever, titles have no
+// section to match the section conditions.
+func (filter SearchFilter) allows(retriever string) bool {
+	if retriever == SearchRetrieverTitle && (len(filter.Sections) > 0 || filter.LevelMin > 0 || filter.LevelMax > 0) {
+		return false
+	}
+	if len(filter.Retrievers) == 0 {
+		return true
+	}
+	for _, name := range filter.Retrievers {
+		if name == retriever {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchHasTerm tells whether term matches any section once tokenized, for
+// stemming tokenizers whose vocabulary holds stems instead of words.
+func (h *DBHandler) SearchHasTerm(ctx context.Context, term string) bool {
+	if h.config.Tokenizer == TokenizerDefault || h.config.Tokenizer == TokenizerUnicode {
+		return false
+	}
+	var count int
+	h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT 1 FROM section_search WHERE section_search MATCH ? LIMIT 1)", queryFTS(term, h.stemmer(), nil, nil)).Scan(&count)
+	return count > 0
+}
+
+// SearchWordDistance finds the vocabulary terms closest to inputWord through
+// the trigram index, scanning the whole vocabulary of databases built before
+// it existed.
+func (h *DBHandler) SearchWordDistance(ctx context.Context, inputWord string, limit int) ([]SearchResult, error) {
+	if h.VocabularyHasIndex(ctx) {
+		return h.VocabularyFuzzy(ctx, inputWord, limit)
+	}
+
+	start := time.Now()
+	var allMatches []SearchResult
+	seen := make(map[string]bool)
+
+	batchSize := 100 * 1000
+	offset := 0
+
+	for {
+		rows, err := h.db.QueryContext(ctx, "SELECT term FROM vocabulary LIMIT ? OFFSET ?", batchSize, offset)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		processed := 0
+		for rows.Next() {
+			var word string
+			if err := rows.Scan(&word); err != nil {
+				return nil, err
+			}
+
+			if seen[word] {
+				continue
+			}
+			seen[word] = true
+
+			distance := LevenshteinDistance(inputWord, word)
+
+			allMatches = append(allMatches, SearchResult{Text: word, Power: float64(distance)})
+
+			if len(allMatches) > limit {
+				sort.Slice(allMatches, func(i, j int) bool {
+					return allMatches[i].Power < allMatches[j].Power
+				})
+				allMatches = allMatches[:limit]
+			}
+
+			processed++
+		}
+
+		if processed < batchSize {
+			break
+		}
+		offset += batchSize
+	}
+
+	sort.Slice(allMatches, func(i, j int) bool {
+		return allMatches[i].Power < allMatches[j].Power
+	})
+
+	log.Printf("Search word distance: %s (%v)", inputWord, time.Since(start))
+
+	return allMatches, nil
+}
+
+// annCandidates is the number of ANN candidates taken per result when they are
+// rescored with the full vectors or filtered.
+const annCandidates = 4
+
+func (h *DBHandler) SearchVectors(ctx context.Context, query string, limit int, snippet int, filter SearchFilter) ([]SearchResult, error) {
+	hasAnn := h.AiHasANN(ctx)
+	hasVectors := h.AiHasVectors(ctx)
+
+	if !hasAnn && !hasVectors {
+		log.Println("Warning, embeddings search requested but not available")
+		return nil, nil
+	}
+
+	start := time.Now()
+	topResults := make([]VectorDistance, 0, limit)
+	sqlQuery := "SELECT id, embedding FROM vectors WHERE 1 = 1"
+	filterSQL, filterArgs := searchFilterSQL(filter, "s.article_id", "s")
+	if filterSQL != "" {
+		sqlQuery += " AND id IN (SELECT s.id FROM sections s WHERE 1 = 1" + filterSQL + ")"
+	}
+
+	stageStart := time.Now()
+	queryEmbedding, err := h.queryEmbeddings(ctx, h.config.AiModelPrefixSearch+QueryText(query))
+	if err != nil {
+		return nil, err
+	}
+	searchStage(ctx, "semantic.embedding", stageStart)
+
+	candidates := make(map[int64]*SearchExplainAnn)
+	if hasAnn {
+		annLimit := limit
+		if hasVectors || filterSQL != "" {
+			annLimit = limit * annCandidates
+		}
+		topAnnResults, err := h.SearchAnn(ctx, queryEmbedding, h.config.AiAnnMode, h.config.AiAnnSize, annLimit)
+		if err != nil {
+			return nil, err
+		}
+		stageStart = time.Now()
+		var vectors_ids []int64
+		var vectors_ids_string []string
+		for i, v := range topAnnResults {
+			vectors_id, err := h.annID(ctx, v)
+			if err != nil {
+				return nil, err
+			}
+			vectors_ids = append(vectors_ids, vectors_id)
+			vectors_ids_string = append(vectors_ids_string, strconv.FormatInt(vectors_id, 10))
+			candidates[vectors_id] = &SearchExplainAnn{Rank: i + 1, Distance: float64(v.Distance), Chunk: v.ChunkRowID, Position: v.ChunkPosition}
+		}
+		searchStage(ctx, "semantic.ann_lookup", stageStart)
+		if hasVectors {
+			sqlQuery += " AND id IN (" + strings.Join(vectors_ids_string, ",") + ")"
+		} else {
+			allowed := make(map[int64]bool)
+			if filterSQL != "" && len(vectors_ids_string) > 0 {
+				rows, err := h.db.QueryContext(ctx, "SELECT s.id FROM sections s WHERE s.id IN ("+strings.Join(vectors_ids_string, ",")+")"+filterSQL, filterArgs...)
+				if err != nil {
+					return nil, err
+				}
+				for rows.Next() {
+					var id int64
+					if err := rows.Scan(&id); err != nil {
+						rows.Close()
+						return nil, err
+					}
+					allowed[id] = true
+				}
+				rows.Close()
+			}
+			for _, id := range vectors_ids {
+				if (filterSQL == "" || allowed[id]) && len(topResults) < limit {
+					topResults = append(topResults, VectorDistance{ID: id, Distance: float32(candidates[id].Distance)})
+				}
+			}
+		}
+
+	}
+
+	if hasVectors {
+		stageStart = time.Now()
+		rows, err := h.db.QueryContext(ctx, sqlQuery, filterArgs...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var embeddingBlob []byte
+			var ID int64
+			if err := rows.Scan(&ID, &embeddingBlob); err != nil {
+				return nil, err
+			}
+			embedding := BytesToFloat32(embeddingBlob)
+			distance, err := EuclideanDistance(queryEmbedding, embedding)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(topResults) < limit {
+				topResults = append(topResults, VectorDistance{ID: ID, Distance: float32(distance)})
+			} else {
+				maxIndex := -1
+				maxDistance := float32(-1)
+				for i := range topResults {
+					if topResults[i].Distance > maxDistance {
+						maxDistance = topResults[i].Distance
+						maxIndex = i
+					}
+				}
+				if maxIndex >= 0 && float32(distance) < maxDistance {
+					topResults[maxIndex] = VectorDistance{ID: ID, Distance: float32(distance)}
+				}
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		searchStage(ctx, "semantic.scan", stageStart)
+	}
+
+	sort.SliceStable(topResults, func(i, j int) bool {
+		return topResults[i].Distance < topResults[j].Distance
+	})
+
+	table := "vectors"
+	if !hasVectors {
+		table = "vectors_ann_chunks"
+	}
+
+	stageStart = time.Now()
+	var results []SearchResult
+	for _, vd := range topResults {
+		var result SearchResult
+		err := h.db.QueryRowContext(ctx, "SELECT a.id, a.title, s.id, COALESCE(s.title, '') FROM articles a JOIN sections s ON a.id = s.article_id WHERE s.id = ?", vd.ID).Scan(
+			&result.ArticleID,
+			&result.Title,
+			&result.SectionID,
+			&result.SectionTitle,
+		)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		content, err := h.SectionContent(ctx, int(vd.ID))
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		snippetApply(&result, snippetSelect(content, query, snippetLength(snippet), h.snippetStemmer()))
+
+		result.Type = "V"
+		result.Power = float64(vd.Distance)
+		result.explain = &SearchExplain{Table: table, SectionID: int(vd.ID), Ann: candidates[vd.ID]}
+		results = append(results, result)
+	}
+	searchStage(ctx, "semantic.sections", stageStart)
+
+	log.Printf("Search vector: %s (%v)", query, time.Since(start))
+
+	return results, nil
+}
+
+// queryEmbeddings returns the embedding of a search input, computed once
+// for as long as it stays in the cache.
+func (h *DBHandler) queryEmbeddings(ctx context.Context, input string) ([]float32, error) {
+	if embedding, found := h.embeddings.Get(input); found {
+		return embedding, nil
+	}
+	embedding, err := h.ai.Embeddings(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	h.embeddings.Put(input, embedding)
+	return embedding, nil
+}
+
+// annID returns the section of an ANN result, looked up by chunk for the
+// indexes that do not store it.
+func (h *DBHandler) annID(ctx context.Context, v VectorDistance) (int64, error) {
+	if v.ID != 0 {
+		return v.ID, nil
+	}
+	var id int64
+	err := h.db.QueryRowContext(ctx, "SELECT vectors_id FROM vectors_ann_index WHERE chunk_id = ? AND chunk_position = ? LIMIT 1", v.ChunkRowID, v.ChunkPosition).Scan(&id)
+	return id, err
+}
+
+func (h *DBHandler) SearchAnn(ctx context.Context, vectors []float32, mode string, size int, limit int) ([]VectorDistance, error) {
+	if mode == "hnsw" {
+		return h.SearchHNSW(ctx, vectors, size, limit)
+	}
+	if mode == "ivf" {
+		return h.SearchIVF(ctx, vectors, size, limit)
+	}
+	if mode == "pq" {
+		return h.SearchPQ(ctx, vectors, size, limit)
+	}
+
+	start := time.Now()
+	var chunkSize int
+	var distance func(vector []byte) float32
+	if mode == "mrl" {
+		mrlQuery := vectors
+		if len(mrlQuery) > size {
+			mrlQuery = mrlQuery[:size]
+		}
+		if len(mrlQuery) != size {
+			return nil, fmt.Errorf("vectors must have the same length")
+		}
+		chunkSize = size * 4
+		distance = func(vector []byte) float32 {
+			return hnswDistance(mrlQuery, BytesToFloat32(vector))
+		}
+	} else if mode == "binary" {
+		quantizedQuery := QuantizeBinary(vectors)
+		chunkSize = len(quantizedQuery)
+		distance = func(vector []byte) float32 {
+			d, _ := HammingDistance(quantizedQuery, vector)
+			return d * d
+		}
+	} else {
+		return nil, fmt.Errorf("invalid ANN mode")
+	}
+
+	rows, err := h.db.QueryContext(ctx, "SELECT id, chunk FROM vectors_ann_chunks")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	topAnnResults, err := annScan(rows, chunkSize, limit, distance)
+	if err != nil {
+		return nil, err
+	}
+
+	searchStage(ctx, "semantic.ann", start)
+	log.Printf("Search ANN time: %v", time.Since(start))
+	return topAnnResults, nil
+}
+
+// annScan returns the limit vectors of chunkSize bytes in the chunk rows with
+// the lowest squared distance, as measured by distance, and their Euclidean
+// distance.
+func annScan(rows *sql.Rows, chunkSize int, limit int, distance func(vector []byte) float32) ([]VectorDistance, error) {
+	results := &hnswHeap{max: true}
+	for rows.Next() {
+		var chunkRowID int64
+		var chunk []byte
+		if err := rows.Scan(&chunkRowID, &chunk); err != nil {
+			return nil, err
+		}
+		for position := 0; position+chunkSize <= len(chunk); position += chunkSize {
+			d := distance(chunk[position : position+chunkSize])
+			if results.Len() < limit || d < results.items[0].Distance {
+				heap.Push(results, VectorDistance{ChunkRowID: chunkRowID, ChunkPosition: position / chunkSize, Distance: d})
+				if results.Len() > limit {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	topAnnResults := make([]VectorDistance, results.Len())
+	for i := len(topAnnResults) - 1; i >= 0; i-- {
+		topAnnResults[i] = heap.Pop(results).(VectorDistance)
+		topAnnResults[i].Distance = float32(math.Sqrt(float64(topAnnResults[i].Distance)))
+	}
+	return topAnnResults, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func (h *DBHandler) Stats(ctx context.Context) (DBStats, error) {
+	stats := DBStats{
+		Tables: map[string]int64{},
+	}
+
+	stats.Version, _ = h.SetupGet(ctx, "version")
+	stats.Language, _ = h.SetupGet(ctx, "language")
+	stats.Tokenizer, _ = h.SetupGet(ctx, "tokenizer")
+	stats.Model, _ = h.SetupGet(ctx, "model")
+	stats.ModelPrefixSave, _ = h.SetupGet(ctx, "modelPrefixSave")
+	stats.ModelPrefixSearch, _ = h.SetupGet(ctx, "modelPrefixSearch")
+	stats.AnnMode, _ = h.SetupGet(ctx, "annMode")
+	if annSize, err := h.SetupGet(ctx, "annSize"); err == nil {
+		stats.AnnSize = extractNumberFromString(annSize)
+	}
+
+	counters := []struct {
+		query string
+		value *int64
+	}{
+		{"SELECT COUNT(*) FROM articles", &stats.Articles},
+		{"SELECT COUNT(*) FROM sections", &stats.Sections},
+		{"SELECT COUNT(*) FROM sections WHERE content IS NULL AND content_flate IS NOT NULL", &stats.SectionsCompressed},
+		{"SELECT COUNT(*) FROM sections WHERE content IS NOT NULL", &stats.SectionsPlain},
+		{"SELECT COUNT(*) FROM vectors", &stats.Vectors},
+		{"SELECT COUNT(*) FROM article_vectors", &stats.ArticleVectors},
+		{"SELECT COUNT(*) FROM vectors_ann_chunks", &stats.AnnChunks},
+		{"SELECT (SELECT COUNT(*) FROM vectors_ann_index) + (SELECT COUNT(*) FROM vectors_hnsw)", &stats.AnnVectors},
+		{"SELECT MAX((SELECT COUNT(*) FROM vocabulary_terms), (SELECT COUNT(DISTINCT term) FROM vocabulary))", &stats.Vocabulary},
+		{"SELECT COALESCE(SUM(length(value)), 0) FROM setup WHERE key = 'gguf'", &stats.ModelSize},
+		{"SELECT COALESCE(SUM(length(value)), 0) FROM setup WHERE key = 'gguf_rerank'", &stats.RerankModelSize},
+		{"SELECT COALESCE(SUM(length(value)), 0) FROM setup WHERE key = 'gguf_chat'", &stats.ChatModelSize},
+		{"SELECT COALESCE((SELECT length(embedding) / 4 FROM vectors LIMIT 1), (SELECT CAST(value AS INTEGER) FROM setup WHERE key = 'embeddingDimension'), 0)", &stats.EmbeddingDimension},
+	}
+	for _, counter := range counters {
+		if err := h.db.QueryRowContext(ctx, counter.query).Scan(counter.value); err != nil {
+			return stats, fmt.Errorf("error executing query %s: %v", counter.query, err)
+		}
+	}
+	stats.ModelEmbedded = stats.ModelSize > 0
+
+	var pageCount, pageSize int64
+	if err := h.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pageCount); err != nil {
+		return stats, fmt.Errorf("error reading page count: %v", err)
+	}
+	if err := h.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return stats, fmt.Errorf("error reading page size: %v", err)
+	}
+	stats.Size = pageCount * pageSize
+
+	rows, err := h.db.QueryContext(ctx, "SELECT name, SUM(pgsize) FROM dbstat GROUP BY name")
+	if err != nil {
+		// dbstat is an optional SQLite module, table sizes are simply omitted without it.
+		return stats, nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var size int64
+		if err := rows.Scan(&name, &size); err != nil {
+			return stats, fmt.Errorf("error scanning table size: %v", err)
+		}
+		stats.Tables[name] = size
+	}
+
+	return stats, rows.Err()
+}
+
+func (s DBStats) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Version: %s\n", s.Version)
+	fmt.Fprintf(&b, "Language: %s\n", s.Language)
+	fmt.Fprintf(&b, "Tokenizer: %s\n", s.Tokenizer)
+	fmt.Fprintf(&b, "Model: %s\n", s.Model)
+	fmt.Fprintf(&b, "Model prefix save: %q\n", s.ModelPrefixSave)
+	fmt.Fprintf(&b, "Model prefix search: %q\n", s.ModelPrefixSearch)
+	fmt.Fprintf(&b, "Model embedded: %v (%d bytes)\n", s.ModelEmbedded, s.ModelSize)
+	fmt.Fprintf(&b, "Rerank model embedded: %v (%d bytes)\n", s.RerankModelSize > 0, s.RerankModelSize)
+	fmt.Fprintf(&b, "Chat model embedded: %v (%d bytes)\n", s.ChatModelSize > 0, s.ChatModelSize)
+	fmt.Fprintf(&b, "Embedding dimension: %d\n", s.EmbeddingDimension)
+	fmt.Fprintf(&b, "ANN mode: %s\n", s.AnnMode)
+	fmt.Fprintf(&b, "ANN size: %d\n", s.AnnSize)
+	fmt.Fprintf(&b, "Articles: %d\n", s.Articles)
+	fmt.Fprintf(&b, "Sections: %d (compressed %d, plain %d)\n", s.Sections, s.SectionsCompressed, s.SectionsPlain)
+	fmt.Fprintf(&b, "Vectors: %d\n", s.Vectors)
+	fmt.Fprintf(&b, "Article vectors: %d\n", s.ArticleVectors)
+	fmt.Fprintf(&b, "ANN vectors: %d in %d chunks\n", s.AnnVectors, s.AnnChunks)
+	fmt.Fprintf(&b, "Vocabulary: %d\n", s.Vocabulary)
+	fmt.Fprintf(&b, "Size: %d bytes\n", s.Size)
+	for _, cache := range s.Caches {
+		fmt.Fprintf(&b, "Cache %s: %d/%d entries, %d hits, %d misses (%.1f%%)\n", cache.Name, cache.Entries, cache.Capacity, cache.Hits, cache.Misses, cache.HitRate*100)
+	}
+
+	if len(s.Tables) > 0 {
+		names := make([]string, 0, len(s.Tables))
+		for name := range s.Tables {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return s.Tables[names[i]] > s.Tables[names[j]]
+		})
+		fmt.Fprintf(&b, "Tables:\n")
+		for _, name := range names {
+			fmt.Fprintf(&b, "  %-50s %d\n", name, s.Tables[name])
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,227 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	suggestBatch     = 10000
+	suggestTopLength = 3
+	suggestTopSize   = 10
+)
+
+// suggestIndexes are the indexes of the lookups by title, alias and entity,
+// built with the suggestions rather than when the database is opened, which
+// would stall on a large database and fail on a read-only one. The lookups
+// still work without them, scanning the tables.
+var suggestIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS idx_articles_entity ON articles(entity)`,
+	`CREATE INDEX IF NOT EXISTS idx_articles_title_nocase ON articles(title COLLATE NOCASE)`,
+	`CREATE INDEX IF NOT EXISTS idx_article_aliases_title_nocase ON article_aliases(title COLLATE NOCASE)`,
+}
+
+// suggestIndexesCheck warns when the title, alias and entity lookups are
+// not indexed.
+func (h *DBHandler) suggestIndexesCheck(ctx context.Context) {
+	var count int
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name IN ('idx_articles_entity', 'idx_articles_title_nocase', 'idx_article_aliases_title_nocase')").Scan(&count); err == nil && count < len(suggestIndexes) {
+		log.Printf("Warning, title and entity lookups are not indexed, run --db-suggest to build the indexes")
+	}
+}
+
+// ProcessSuggest rebuilds the title autocomplete index from the article titles
+// and their aliases, ranked by the number of sections of the article. The
+// best suggestions for prefixes up to suggestTopLength letters are stored
+// apart, since those match too many titles to be ranked on every request.
+// The title, alias and entity indexes are built first.
+func (h *DBHandler) ProcessSuggest(ctx context.Context) error {
+	for _, query := range suggestIndexes {
+		if _, err := h.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("error creating title indexes: %v", err)
+		}
+	}
+
+	for _, table := range []string{"article_suggest", "article_suggest_top"} {
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return fmt.Errorf("error clearing %s table: %v", table, err)
+		}
+	}
+
+	type suggestEntry struct {
+		articleID int64
+		title     string
+		rank      int64
+	}
+
+	lastID := int64(0)
+	for {
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT a.id, a.title, (SELECT COUNT(*) FROM sections WHERE article_id = a.id)
+			FROM articles a
+			WHERE a.id > ?
+			ORDER BY a.id
+			LIMIT ?
+		`, lastID, suggestBatch)
+		if err != nil {
+			return fmt.Errorf("error reading articles: %v", err)
+		}
+		var entries []suggestEntry
+		for rows.Next() {
+			var entry suggestEntry
+			if err := rows.Scan(&entry.articleID, &entry.title, &entry.rank); err != nil {
+				rows.Close()
+				return fmt.Errorf("error reading articles: %v", err)
+			}
+			entries = append(entries, entry)
+			lastID = entry.articleID
+		}
+		rows.Close()
+		if len(entries) == 0 {
+			break
+		}
+
+		aliases, err := h.db.QueryContext(ctx, "SELECT article_id, title FROM article_aliases WHERE article_id > ? AND article_id <= ?", entries[0].articleID-1, lastID)
+		if err != nil {
+			return fmt.Errorf("error reading aliases: %v", err)
+		}
+		ranks := make(map[int64]int64)
+		for _, entry := range entries {
+			ranks[entry.articleID] = entry.rank
+		}
+		for aliases.Next() {
+			var entry suggestEntry
+			if err := aliases.Scan(&entry.articleID, &entry.title); err != nil {
+				aliases.Close()
+				return fmt.Errorf("error reading aliases: %v", err)
+			}
+			entry.rank = ranks[entry.articleID]
+			entries = append(entries, entry)
+		}
+		aliases.Close()
+
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %v", err)
+		}
+		stmt, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO article_suggest (key, article_id, title, rank) VALUES (?, ?, ?, ?)")
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error preparing statement: %v", err)
+		}
+		for _, entry := range entries {
+			key := SuggestKey(entry.title)
+			if key == "" {
+				continue
+			}
+			if _, err := stmt.ExecContext(ctx, key, entry.articleID, entry.title, entry.rank); err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return fmt.Errorf("error inserting suggestion: %v", err)
+			}
+		}
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing suggestions: %v", err)
+		}
+	}
+
+	for length := 1; length <= suggestTopLength; length++ {
+		_, err := h.db.ExecContext(ctx, `
+			INSERT INTO article_suggest_top (prefix, article_id, title, rank)
+			SELECT prefix, article_id, title, rank FROM (
+				SELECT prefix, article_id, title, rank,
+					ROW_NUMBER() OVER (PARTITION BY prefix ORDER BY rank DESC, length(title), title) AS position
+				FROM (
+					SELECT substr(key, 1, ?) AS prefix, article_id, MIN(title) AS title, MAX(rank) AS rank
+					FROM article_suggest
+					WHERE length(key) >= ?
+					GROUP BY prefix, article_id
+				)
+			)
+			WHERE position <= ?
+		`, length, length, suggestTopSize)
+		if err != nil {
+			return fmt.Errorf("error populating suggestion prefixes: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Suggest returns the titles starting with prefix, the matching title or alias
+// as Text and the article rank as Power. Databases without the suggestion
+// index fall back to a plain title lookup.
+func (h *DBHandler) Suggest(ctx context.Context, prefix string, limit int) ([]SearchResult, error) {
+	start := time.Now()
+	key := SuggestKey(prefix)
+	if key == "" {
+		return nil, nil
+	}
+
+	var sqlQuery string
+	var args []interface{}
+	if !h.SuggestHasIndex(ctx) {
+		sqlQuery = `SELECT id, title, 0 FROM articles WHERE title LIKE ? ESCAPE '\' ORDER BY length(title), title LIMIT ?`
+		args = []interface{}{strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%", limit}
+	} else if utf8.RuneCountInString(key) <= suggestTopLength {
+		sqlQuery = `SELECT article_id, title, rank FROM article_suggest_top WHERE prefix = ? ORDER BY rank DESC, length(title), title LIMIT ?`
+		args = []interface{}{key, limit}
+	} else {
+		sqlQuery = `
+			SELECT article_id, MIN(title), MAX(rank) AS rank
+			FROM article_suggest
+			WHERE key >= ? AND key < ?
+			GROUP BY article_id
+			ORDER BY rank DESC, length(MIN(title)), MIN(title)
+			LIMIT ?
+		`
+		args = []interface{}{key, key + string(utf8.MaxRune), limit}
+	}
+
+	rows, err := h.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(&result.ArticleID, &result.Text, &result.Power); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		if err := h.db.QueryRowContext(ctx, "SELECT title FROM articles WHERE id = ?", results[i].ArticleID).Scan(&results[i].Title); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Printf("Suggest: %s (%v)", key, time.Since(start))
+
+	return results, nil
+}
+
+func (h *DBHandler) SuggestHasIndex(ctx context.Context) bool {
+	var count int
+	h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT 1 FROM article_suggest_top LIMIT 1)").Scan(&count)
+	return count > 0
+}
+
+// SuggestKey normalizes a title for prefix matching: lower case, underscores
+// as spaces and single spaces between words.
+func SuggestKey(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(title, "_", " ")), " "))
+}
@@ -0,0 +1,332 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	vocabularyBatch      = 10000
+	vocabularyCandidates = 200
+	vocabularyVectorsMin = 3
+)
+
+// ProcessVocabulary rebuilds the vocabulary from the full text indexes, with
+// the number of occurrences of every term and its trigrams for fuzzy lookups.
+func (h *DBHandler) ProcessVocabulary(ctx context.Context) error {
+	for _, table := range []string{"vocabulary", "vocabulary_terms", "vocabulary_trigrams"} {
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return fmt.Errorf("error clearing %s table: %v", table, err)
+		}
+	}
+
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO vocabulary_terms (term, frequency)
+		SELECT term, SUM(cnt) FROM (
+			SELECT term, cnt FROM article_search_vocabulary
+			UNION ALL
+			SELECT term, cnt FROM section_search_vocabulary
+		) GROUP BY term
+	`)
+	if err != nil {
+		return fmt.Errorf("error populating vocabulary table: %v", err)
+	}
+
+	lastID := int64(0)
+	for {
+		rows, err := h.db.QueryContext(ctx, "SELECT id, term FROM vocabulary_terms WHERE id > ? ORDER BY id LIMIT ?", lastID, vocabularyBatch)
+		if err != nil {
+			return fmt.Errorf("error reading vocabulary: %v", err)
+		}
+		terms := make(map[int64]string)
+		for rows.Next() {
+			var term string
+			if err := rows.Scan(&lastID, &term); err != nil {
+				rows.Close()
+				return fmt.Errorf("error reading vocabulary: %v", err)
+			}
+			terms[lastID] = term
+		}
+		rows.Close()
+		if len(terms) == 0 {
+			break
+		}
+
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %v", err)
+		}
+		stmt, err := tx.PrepareContext(ctx, "INSERT OR IGNORE INTO vocabulary_trigrams (trigram, term_id) VALUES (?, ?)")
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error preparing statement: %v", err)
+		}
+		for id, term := range terms {
+			if !vocabularyIndexable(term) {
+				continue
+			}
+			for _, trigram := range vocabularyTrigrams(term) {
+				if _, err := stmt.ExecContext(ctx, trigram, id); err != nil {
+					stmt.Close()
+					tx.Rollback()
+					return fmt.Errorf("error inserting trigram: %v", err)
+				}
+			}
+		}
+		stmt.Close()
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing trigrams: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (h *DBHandler) VocabularyHasIndex(ctx context.Context) bool {
+	var count int
+	h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT 1 FROM vocabulary_trigrams LIMIT 1)").Scan(&count)
+	return count > 0
+}
+
+// VocabularyFrequency returns the occurrences of term in the indexes, zero
+// when it is unknown.
+func (h *DBHandler) VocabularyFrequency(ctx context.Context, term string) (frequency int64) {
+	h.db.QueryRowContext(ctx, "SELECT frequency FROM vocabulary_terms WHERE term = ?", term).Scan(&frequency)
+	return
+}
+
+// vocabularyFrequencies returns the occurrences of the known terms among
+// terms, with a single query.
+func (h *DBHandler) vocabularyFrequencies(ctx context.Context, terms []string) (map[string]int64, error) {
+	frequencies := make(map[string]int64)
+	if len(terms) == 0 {
+		return frequencies, nil
+	}
+	args := make([]interface{}, len(terms))
+	for i, term := range terms {
+		args[i] = term
+	}
+	rows, err := h.db.QueryContext(ctx, "SELECT term, frequency FROM vocabulary_terms WHERE term IN (?"+strings.Repeat(", ?", len(terms)-1)+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var term string
+		var frequency int64
+		if err := rows.Scan(&term, &frequency); err != nil {
+			return nil, err
+		}
+		frequencies[term] = frequency
+	}
+	return frequencies, rows.Err()
+}
+
+// VocabularyFuzzy returns the terms closest to word by Levenshtein distance,
+// stored as Power, among the ones sharing most trigrams with it. Ties go to
+// the most frequent term.
+func (h *DBHandler) VocabularyFuzzy(ctx context.Context, word string, limit int) ([]SearchResult, error) {
+	start := time.Now()
+	word = strings.ToLower(word)
+
+	trigrams := vocabularyTrigrams(word)
+	if len(trigrams) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, 0, len(trigrams)+1)
+	for _, trigram := range trigrams {
+		args = append(args, trigram)
+	}
+	args = append(args, vocabularyCandidates)
+
+	sqlQuery := `
+		SELECT t.term, t.frequency
+		FROM vocabulary_trigrams g
+		JOIN vocabulary_terms t ON t.id = g.term_id
+		WHERE g.trigram IN (?` + strings.Repeat(", ?", len(trigrams)-1) + `)
+		GROUP BY g.term_id
+		ORDER BY COUNT(*) DESC, t.frequency DESC
+		LIMIT ?
+	`
+	rows, err := h.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []SearchResult
+	frequencies := make(map[string]int64)
+	for rows.Next() {
+		var term string
+		var frequency int64
+		if err := rows.Scan(&term, &frequency); err != nil {
+			return nil, err
+		}
+		frequencies[term] = frequency
+		matches = append(matches, SearchResult{Text: term, Power: float64(LevenshteinDistance(word, term))})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Power != matches[j].Power {
+			return matches[i].Power < matches[j].Power
+		}
+		return frequencies[matches[i].Text] > frequencies[matches[j].Text]
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	log.Printf("Search vocabulary: %s (%v)", word, time.Since(start))
+
+	return matches, nil
+}
+
+// ProcessVocabularyVectors embeds the limit most frequent terms of the
+// vocabulary, replacing the previous ones, so that lexical queries can be
+// expanded with their neighbours. Numbers and terms shorter than
+// vocabularyVectorsMin are left out.
+func (h *DBHandler) ProcessVocabularyVectors(ctx context.Context, limit int) error {
+	rows, err := h.db.QueryContext(ctx, "SELECT term FROM vocabulary_terms WHERE length(term) >= ? ORDER BY frequency DESC", vocabularyVectorsMin)
+	if err != nil {
+		return fmt.Errorf("error reading vocabulary: %v", err)
+	}
+	var terms []string
+	for rows.Next() && len(terms) < limit {
+		var term string
+		if err := rows.Scan(&term); err != nil {
+			rows.Close()
+			return fmt.Errorf("error reading vocabulary: %v", err)
+		}
+		if vocabularyIndexable(term) {
+			terms = append(terms, term)
+		}
+	}
+	rows.Close()
+
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM vocabulary_vectors"); err != nil {
+		return fmt.Errorf("error clearing vocabulary_vectors table: %v", err)
+	}
+
+	startTime := time.Now()
+	for processed := 0; processed < len(terms); processed += vocabularyBatch {
+		batch := terms[processed:min(processed+vocabularyBatch, len(terms))]
+
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("error starting transaction: %v", err)
+		}
+		for _, term := range batch {
+			embedding, err := h.ai.Embeddings(ctx, term)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("error embedding term %s: %v", term, err)
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO vocabulary_vectors (term, embedding) VALUES (?, ?)", term, Float32ToBytes(embedding)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("error inserting term vector: %v", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing term vectors: %v", err)
+		}
+
+		log.Printf("Vocabulary embedding progress: %d/%d (%v)", processed+len(batch), len(terms), time.Since(startTime).Truncate(time.Second))
+	}
+
+	return nil
+}
+
+func (h *DBHandler) VocabularyHasVectors(ctx context.Context) bool {
+	var count int
+	h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM (SELECT 1 FROM vocabulary_vectors LIMIT 1)").Scan(&count)
+	return count > 0
+}
+
+// VocabularyNeighbors returns the embedded terms closest in meaning to word
+// whose cosine similarity, stored as Power, reaches similarity, the most
+// similar first. The word itself is left out.
+func (h *DBHandler) VocabularyNeighbors(ctx context.Context, word string, limit int, similarity float64) ([]SearchResult, error) {
+	start := time.Now()
+	word = strings.ToLower(word)
+
+	wordEmbedding, err := h.queryEmbeddings(ctx, word)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.QueryContext(ctx, "SELECT term, embedding FROM vocabulary_vectors")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []SearchResult
+	for rows.Next() {
+		var term string
+		var embeddingBlob []byte
+		if err := rows.Scan(&term, &embeddingBlob); err != nil {
+			return nil, err
+		}
+		if term == word {
+			continue
+		}
+		power, err := CosineSimilarity(wordEmbedding, BytesToFloat32(embeddingBlob))
+		if err != nil {
+			return nil, err
+		}
+		if float64(power) >= similarity {
+			matches = append(matches, SearchResult{Text: term, Power: float64(power)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Power > matches[j].Power
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	log.Printf("Search vocabulary neighbours: %s (%v)", word, time.Since(start))
+
+	return matches, nil
+}
+
+// vocabularyTrigrams splits a term padded with $ into its distinct trigrams,
+// so that short terms and word boundaries count as well.
+func vocabularyTrigrams(term string) []string {
+	runes := []rune("$" + term + "$")
+	seen := make(map[string]bool)
+	var trigrams []string
+	for i := 0; i+3 <= len(runes); i++ {
+		trigram := string(runes[i : i+3])
+		if !seen[trigram] {
+			seen[trigram] = true
+			trigrams = append(trigrams, trigram)
+		}
+	}
+	return trigrams
+}
+
+// vocabularyIndexable leaves numbers out of the fuzzy index, correcting them
+// makes no sense and they are a large part of the vocabulary.
+func vocabularyIndexable(term string) bool {
+	for _, r := range term {
+		if unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,6 @@
 // Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
 
-package main
+package wikilite
 
 import (
 	"fmt"
@@ -22,6 +22,24 @@ func EuclideanDistance(a, b []float32) (float32, error) {
 	return float32(math.Sqrt(float64(sum))), nil
 }
 
+func CosineSimilarity(a, b []float32) (float32, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("vectors must have the same length")
+	}
+
+	var dot, normA, normB float64
+	for i := 0; i < len(a); i++ {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0, nil
+	}
+
+	return float32(dot / math.Sqrt(normA*normB)), nil
+}
+
 func HammingDistance(a, b []byte) (float32, error) {
 	if len(a) != len(b) {
 		return 0, fmt.Errorf("bit arrays must have the same length")
@@ -0,0 +1,326 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+// Package wikilite searches and builds Wikilite SQLite databases: FTS5
+// lexical search, embedding based semantic search and Wikipedia imports.
+package wikilite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const Version = "0.27.3"
+
+// Config holds the settings of an Engine. Values stored in the database setup
+// table take precedence over the ones given here when the database is opened.
+type Config struct {
+	Path                string
+	Language            string
+	AiAnn               bool
+	AiAnnMode           string
+	AiAnnSize           int
+	AiAnnM              int
+	AiAnnEfConstruction int
+	AiAnnEfSearch       int
+	AiAnnLists          int
+	AiAnnProbe          int
+	AiAnnSubvectors     int
+	AiApi               bool
+	AiApiKey            string
+	AiApiUrl            string
+	AiModel             string
+	AiModelPrefixSave   string
+	AiModelPrefixSearch string
+	AiRerankApiUrl      string
+	AiRerankModel       string
+	// AiChatApiUrl is the API of the chat model used by Ask in place of the
+	// one stored in the database, either loaded or called only once asked.
+	AiChatApiUrl string
+	AiChatModel  string
+	AiThreads    int
+	Tokenizer    string
+	// SearchRerank is the number of top results of Search rescored by the
+	// rerank model, stored in the database or behind AiRerankApiUrl, when
+	// Rerank reports true.
+	SearchRerank int
+	// SearchTimeout, when set, makes a longer search return the results of
+	// the retrievers done in time along with ErrSearchPartial.
+	SearchTimeout time.Duration
+	// CacheSize bounds the query embeddings and result pages kept in memory,
+	// with 20 times as many HNSW nodes, zero disables the caches.
+	CacheSize  int
+	SearchRrfK int
+	// The search weights scale the retrievers fused by Search, one with a
+	// zero weight is left out. When none is set they default to 1, and to
+	// 0.5 for the query expansion, which also needs the embedding model and
+	// the vocabulary vectors built by ProcessVocabularyVectors.
+	SearchWeightTitle     float64
+	SearchWeightContent   float64
+	SearchWeightSemantic  float64
+	SearchWeightExpansion float64
+}
+
+// Engine wraps a single database and the embedding, rerank and chat models
+// used to query it.
+type Engine struct {
+	ID     string
+	db     *DBHandler
+	ai     bool
+	rerank bool
+}
+
+// Open opens or creates the database at config.Path and initializes the
+// embedding and rerank models, semantic search is available only when AI
+// reports true.
+func Open(ctx context.Context, config Config) (*Engine, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("database path is required")
+	}
+	if config.SearchRrfK <= 0 {
+		config.SearchRrfK = searchRrfK
+	}
+	if config.SearchWeightTitle == 0 && config.SearchWeightContent == 0 && config.SearchWeightSemantic == 0 && config.SearchWeightExpansion == 0 {
+		config.SearchWeightTitle = 1
+		config.SearchWeightContent = 1
+		config.SearchWeightSemantic = 1
+		config.SearchWeightExpansion = searchWeightExpansion
+	}
+
+	handler, err := NewDBHandler(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &Engine{
+		ID: strings.TrimSuffix(filepath.Base(config.Path), filepath.Ext(config.Path)),
+		db: handler,
+	}
+	if err := engine.aiInit(ctx); err != nil {
+		log.Printf("AI initialization error: %v", err)
+	}
+	if err := engine.rerankInit(ctx); err != nil {
+		log.Printf("Rerank initialization error: %v", err)
+	}
+
+	return engine, nil
+}
+
+func (e *Engine) Close() error {
+	return e.db.Close()
+}
+
+// Config returns the effective configuration, merged with the database setup.
+func (e *Engine) Config() Config {
+	return *e.db.config
+}
+
+func (e *Engine) AI() bool {
+	return e.ai
+}
+
+func (e *Engine) aiInit(ctx context.Context) error {
+	err := e.db.ai.Init(ctx)
+	e.ai = err == nil
+	return err
+}
+
+func (e *Engine) Rerank() bool {
+	return e.rerank
+}
+
+func (e *Engine) rerankInit(ctx context.Context) error {
+	if e.db.config.SearchRerank <= 0 {
+		return nil
+	}
+	err := e.db.ai.RerankInit(ctx)
+	e.rerank = err == nil
+	return err
+}
+
+// Chat reports whether Ask has a chat model, behind Config.AiChatApiUrl or
+// stored in the database, without loading or calling it: the internal model
+// is loaded by the first Ask.
+func (e *Engine) Chat() bool {
+	if e.db.config.AiApi {
+		return e.db.config.AiChatApiUrl != ""
+	}
+	return aiInternal() && e.db.AiHasChatModel(context.Background())
+}
+
+func (e *Engine) ArticleGet(ctx context.Context, articleID int) (ArticleResult, error) {
+	article, err := e.db.ArticleGet(ctx, articleID)
+	if err != nil {
+		return article, err
+	}
+	article.DB = e.ID
+	article.Language = e.db.config.Language
+
+	return article, nil
+}
+
+func (e *Engine) SectionGet(ctx context.Context, sectionID int) (SectionResult, error) {
+	section, err := e.db.SectionGet(ctx, sectionID)
+	if err != nil {
+		return section, err
+	}
+	section.DB = e.ID
+
+	return section, nil
+}
+
+// ArticleRelated returns the limit articles most similar to articleID, by
+// their embeddings once ProcessRelated has stored them, by their distinctive
+// words otherwise.
+func (e *Engine) ArticleRelated(ctx context.Context, articleID int, limit int) ([]SearchResult, error) {
+	results, err := e.db.ArticleRelated(ctx, articleID, limit)
+	for i := range results {
+		results[i].DB = e.ID
+	}
+	return results, err
+}
+
+func (e *Engine) ArticleIDByEntity(ctx context.Context, entity string) (int, error) {
+	return e.db.ArticleIDByEntity(ctx, entity)
+}
+
+func (e *Engine) ArticleIDByTitle(ctx context.Context, title string) (int, error) {
+	return e.db.ArticleIDByTitle(ctx, ArticleTitleNormalize(title))
+}
+
+func (e *Engine) ArticleIDRandom(ctx context.Context) (int, error) {
+	return e.db.ArticleIDRandom(ctx)
+}
+
+func (e *Engine) Stats(ctx context.Context) (DBStats, error) {
+	stats, err := e.db.Stats(ctx)
+	stats.Caches = e.CacheStats()
+	return stats, err
+}
+
+// CacheStats reports the hit rate of the query embedding, result, HNSW node
+// and ANN codebook caches.
+func (e *Engine) CacheStats() []CacheStats {
+	return []CacheStats{e.db.embeddings.Stats(), e.db.results.Stats(), e.db.hnsw.Stats(), e.db.codebooks.Stats()}
+}
+
+// Import loads a Wikipedia Enterprise HTML dump from a URL or a local file
+// and rebuilds the full text indexes.
+func (e *Engine) Import(ctx context.Context, path string) error {
+	return e.db.write(func() error {
+		return e.db.WikiImport(ctx, path)
+	})
+}
+
+// ModelImport stores a GGUF embedding model inside the database.
+func (e *Engine) ModelImport(ctx context.Context, path string) error {
+	err := e.db.write(func() error {
+		return e.db.AiModelImport(ctx, path)
+	})
+	if err == nil && !e.ai {
+		e.aiInit(ctx)
+	}
+	return err
+}
+
+// RerankModelImport stores a llama.cpp reranker GGUF in the database, used by
+// the internal llama.cpp engine for the rerank stage of Search.
+func (e *Engine) RerankModelImport(ctx context.Context, path string) error {
+	err := e.db.write(func() error {
+		return e.db.AiRerankModelImport(ctx, path)
+	})
+	if err == nil && !e.rerank {
+		e.rerankInit(ctx)
+	}
+	return err
+}
+
+// ChatModelImport stores an instruct GGUF with a chat template in the
+// database, used by the internal llama.cpp engine to generate the answers of
+// Ask.
+func (e *Engine) ChatModelImport(ctx context.Context, path string) error {
+	return e.db.write(func() error {
+		return e.db.AiChatModelImport(ctx, path)
+	})
+}
+
+// ProcessEmbeddings generates the missing section embeddings, followed by the
+// ANN index when Config.AiAnn is set.
+func (e *Engine) ProcessEmbeddings(ctx context.Context) error {
+	if !e.ai {
+		return fmt.Errorf("AI is not available")
+	}
+	return e.db.write(func() error {
+		return e.db.ProcessEmbeddings(ctx)
+	})
+}
+
+func (e *Engine) ProcessANN(ctx context.Context) error {
+	return e.db.write(func() error {
+		return e.db.ProcessANN(ctx)
+	})
+}
+
+// ProcessVocabulary rebuilds the vocabulary and its fuzzy index, Import does
+// it already.
+func (e *Engine) ProcessVocabulary(ctx context.Context) error {
+	return e.db.write(func() error {
+		return e.db.ProcessVocabulary(ctx)
+	})
+}
+
+// ProcessVocabularyVectors embeds the limit most frequent vocabulary terms,
+// the neighbours used by SearchOptions.Expand.
+func (e *Engine) ProcessVocabularyVectors(ctx context.Context, limit int) error {
+	if !e.ai {
+		return fmt.Errorf("AI is not available")
+	}
+	return e.db.write(func() error {
+		return e.db.ProcessVocabularyVectors(ctx, limit)
+	})
+}
+
+// ProcessRelated rebuilds the article embeddings from the section ones and
+// stores the articles closest to every article for ArticleRelated, it needs no
+// model.
+func (e *Engine) ProcessRelated(ctx context.Context) error {
+	return e.db.write(func() error {
+		return e.db.ProcessRelated(ctx)
+	})
+}
+
+// ProcessSuggest rebuilds the title autocomplete index, Import does it
+// already.
+func (e *Engine) ProcessSuggest(ctx context.Context) error {
+	return e.db.write(func() error {
+		return e.db.ProcessSuggest(ctx)
+	})
+}
+
+// Tokenize rebuilds the full text indexes with one of the Tokenizer*
+// tokenizers, new databases get Config.Tokenizer when created.
+func (e *Engine) Tokenize(ctx context.Context, tokenizer string) error {
+	return e.db.write(func() error {
+		return e.db.Tokenize(ctx, tokenizer)
+	})
+}
+
+func (e *Engine) Compress(ctx context.Context) error {
+	return e.db.write(func() error {
+		return e.db.Compress(ctx)
+	})
+}
+
+// VectorsDrop deletes the section vectors once ProcessANN has indexed them,
+// the ANN index then being the only source of semantic search. The drop is
+// recorded in the database and from then on ProcessEmbeddings, ProcessANN
+// and ProcessRelated return ErrVectorsDropped, rather than embedding every
+// section again or dropping an index they could not rebuild.
+func (e *Engine) VectorsDrop(ctx context.Context) error {
+	return e.db.write(func() error {
+		return e.db.VectorsDrop(ctx)
+	})
+}
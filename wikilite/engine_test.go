@@ -0,0 +1,141 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenWeights(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   [4]float64
+	}{
+		{"defaults", Config{}, [4]float64{1, 1, 1, searchWeightExpansion}},
+		{"expansion only", Config{SearchWeightExpansion: 2}, [4]float64{0, 0, 0, 2}},
+		{"title only", Config{SearchWeightTitle: 3}, [4]float64{3, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		test.config.Path = filepath.Join(t.TempDir(), "test.db")
+		e, err := Open(context.Background(), test.config)
+		if err != nil && strings.Contains(err.Error(), "fts5") {
+			t.Skipf("FTS5 not available: %v", err)
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		config := e.Config()
+		e.Close()
+		got := [4]float64{config.SearchWeightTitle, config.SearchWeightContent, config.SearchWeightSemantic, config.SearchWeightExpansion}
+		if got != test.want {
+			t.Errorf("%s: weights %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestOpenReadMode(t *testing.T) {
+	ctx := context.Background()
+	e, err := Open(ctx, Config{Path: filepath.Join(t.TempDir(), "test.db")})
+	if err != nil && strings.Contains(err.Error(), "fts5") {
+		t.Skipf("FTS5 not available: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	for i := 0; i < dbIdleConns+1; i++ {
+		conn, err := e.db.db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, "INSERT INTO setup (key, value) VALUES ('test', 'test')"); err == nil {
+			t.Errorf("connection %d writes in read mode", i)
+		}
+	}
+
+	if err := e.db.write(func() error {
+		return e.db.SetupPut(ctx, "test", "test")
+	}); err != nil {
+		t.Errorf("write: %v", err)
+	}
+}
+
+func TestCacheValidate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	e, err := Open(ctx, Config{Path: path, CacheSize: 10})
+	if err != nil && strings.Contains(err.Error(), "fts5") {
+		t.Skipf("FTS5 not available: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	e.db.cacheValidate(ctx)
+	e.db.results.Put("query", []SearchResult{{ArticleID: 1}})
+	e.db.cacheValidate(ctx)
+	if _, found := e.db.results.Get("query"); !found {
+		t.Fatal("results dropped with no change to the database")
+	}
+
+	other, err := sql.Open("sqlite3_wikilite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec("INSERT INTO setup (key, value) VALUES ('test', 'test')"); err != nil {
+		t.Fatal(err)
+	}
+	e.db.cacheValidate(ctx)
+	if _, found := e.db.results.Get("query"); found {
+		t.Error("results kept after another connection wrote the database")
+	}
+}
+
+// testOpenVectors returns an engine whose articles have one section each,
+// with the given embeddings, their IDs starting from 1.
+func testOpenVectors(t *testing.T, config Config, embeddings [][]float32) *Engine {
+	ctx := context.Background()
+	config.Path = filepath.Join(t.TempDir(), "test.db")
+	e, err := Open(ctx, config)
+	if err != nil && strings.Contains(err.Error(), "fts5") {
+		t.Skipf("FTS5 not available: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	testAddVectors(t, e, embeddings)
+	return e
+}
+
+// testAddVectors adds an article for every embedding after the last one.
+func testAddVectors(t *testing.T, e *Engine, embeddings [][]float32) {
+	ctx := context.Background()
+	var last int
+	if err := e.db.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM articles").Scan(&last); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.db.write(func() error {
+		for i, embedding := range embeddings {
+			id := last + i + 1
+			if _, err := e.db.db.ExecContext(ctx, "INSERT INTO articles (id, title, entity) VALUES (?, ?, '')", id, fmt.Sprintf("Article %d", id)); err != nil {
+				return err
+			}
+			if _, err := e.db.db.ExecContext(ctx, "INSERT INTO sections (id, article_id, title, content) VALUES (?, ?, '', ?)", id, id, fmt.Sprintf("Content of article %d", id)); err != nil {
+				return err
+			}
+			if _, err := e.db.db.ExecContext(ctx, "INSERT INTO vectors (id, embedding) VALUES (?, ?)", id, Float32ToBytes(embedding)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
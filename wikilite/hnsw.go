@@ -0,0 +1,366 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+const (
+	hnswM              = 16
+	hnswEfConstruction = 200
+	hnswEfSearch       = 64
+)
+
+// hnswNode is a vector of the hierarchical navigable small world graph with
+// its neighbours on every layer it belongs to, from layer 0 up.
+type hnswNode struct {
+	embedding []float32
+	neighbors [][]int64
+}
+
+// hnswGraph walks a graph whose nodes are returned by node, read from the
+// database, through the HNSW cache, both when building and when searching. It
+// is not safe for concurrent use, the visited set being reused by every layer
+// search.
+type hnswGraph struct {
+	node    func(id int64) (*hnswNode, error)
+	visited map[int64]bool
+}
+
+// hnswDistance is the squared Euclidean distance, ordering the vectors like
+// EuclideanDistance without the square root.
+func hnswDistance(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		diff := a[i] - b[i]
+		sum += diff * diff
+	}
+	return sum
+}
+
+type hnswHeap struct {
+	items []VectorDistance
+	max   bool
+}
+
+func (h hnswHeap) Len() int { return len(h.items) }
+func (h hnswHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].Distance > h.items[j].Distance
+	}
+	return h.items[i].Distance < h.items[j].Distance
+}
+func (h hnswHeap) Swap(i, j int)       { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *hnswHeap) Push(x interface{}) { h.items = append(h.items, x.(VectorDistance)) }
+func (h *hnswHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+// searchLayer returns the ef nodes of layer closest to query reached from
+// entries, the closest first.
+func (g *hnswGraph) searchLayer(query []float32, entries []VectorDistance, ef int, layer int) ([]VectorDistance, error) {
+	if g.visited == nil {
+		g.visited = make(map[int64]bool)
+	}
+	clear(g.visited)
+	visited := g.visited
+	candidates := &hnswHeap{}
+	results := &hnswHeap{max: true}
+	for _, entry := range entries {
+		visited[entry.ID] = true
+		heap.Push(candidates, entry)
+		heap.Push(results, entry)
+	}
+	for results.Len() > ef {
+		heap.Pop(results)
+	}
+
+	for candidates.Len() > 0 {
+		candidate := heap.Pop(candidates).(VectorDistance)
+		if results.Len() >= ef && candidate.Distance > results.items[0].Distance {
+			break
+		}
+		node, err := g.node(candidate.ID)
+		if err != nil {
+			return nil, err
+		}
+		if layer >= len(node.neighbors) {
+			continue
+		}
+		for _, id := range node.neighbors[layer] {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			neighbor, err := g.node(id)
+			if err != nil {
+				return nil, err
+			}
+			distance := hnswDistance(query, neighbor.embedding)
+			if results.Len() < ef || distance < results.items[0].Distance {
+				heap.Push(candidates, VectorDistance{ID: id, Distance: distance})
+				heap.Push(results, VectorDistance{ID: id, Distance: distance})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sort.Slice(results.items, func(i, j int) bool {
+		return results.items[i].Distance < results.items[j].Distance
+	})
+	return results.items, nil
+}
+
+// selectNeighbors keeps up to m of the sorted candidates, skipping the ones
+// closer to an already selected neighbour than to the node so that the links
+// spread in every direction, and filling the remaining places with them.
+func (g *hnswGraph) selectNeighbors(candidates []VectorDistance, m int) ([]int64, error) {
+	var selected []int64
+	var selectedNodes []*hnswNode
+	var pruned []int64
+	for _, candidate := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		node, err := g.node(candidate.ID)
+		if err != nil {
+			return nil, err
+		}
+		diverse := true
+		for _, other := range selectedNodes {
+			if hnswDistance(node.embedding, other.embedding) < candidate.Distance {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, candidate.ID)
+			selectedNodes = append(selectedNodes, node)
+		} else {
+			pruned = append(pruned, candidate.ID)
+		}
+	}
+	for _, id := range pruned {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, id)
+	}
+	return selected, nil
+}
+
+// search returns the limit nodes closest to query, exploring the bottom layer
+// with ef candidates, with their Euclidean distance.
+func (g *hnswGraph) search(query []float32, entry int64, limit int, ef int) ([]VectorDistance, error) {
+	node, err := g.node(entry)
+	if err != nil {
+		return nil, err
+	}
+	if len(node.embedding) != len(query) {
+		return nil, fmt.Errorf("vectors must have the same length")
+	}
+
+	closest := []VectorDistance{{ID: entry, Distance: hnswDistance(query, node.embedding)}}
+	for layer := len(node.neighbors) - 1; layer > 0; layer-- {
+		if closest, err = g.searchLayer(query, closest, 1, layer); err != nil {
+			return nil, err
+		}
+	}
+	if closest, err = g.searchLayer(query, closest, max(ef, limit), 0); err != nil {
+		return nil, err
+	}
+
+	if len(closest) > limit {
+		closest = closest[:limit]
+	}
+	for i := range closest {
+		closest[i].Distance = float32(math.Sqrt(float64(closest[i].Distance)))
+	}
+	return closest, nil
+}
+
+// hnswBuilder inserts vectors one by one into a graph with m neighbours per
+// node, 2*m on layer 0, found among efConstruction candidates. Nodes are read
+// through load and the ones added or changed since the caller last stored
+// and cleared them are kept in nodes, so that only a batch of the graph is in
+// memory. The level of a node is drawn from a hash of its ID so that the same
+// vectors always get the same levels, also across incremental builds.
+type hnswBuilder struct {
+	hnswGraph
+	m              int
+	efConstruction int
+	levelMult      float64
+	load           func(id int64) (*hnswNode, error)
+	nodes          map[int64]*hnswNode
+	entry          int64
+	empty          bool
+}
+
+// newHNSWBuilder extends the graph whose entry point is entry, an empty one
+// when entry is zero.
+func newHNSWBuilder(m int, efConstruction int, load func(id int64) (*hnswNode, error), entry int64) *hnswBuilder {
+	b := &hnswBuilder{
+		m:              m,
+		efConstruction: efConstruction,
+		levelMult:      1 / math.Log(float64(m)),
+		load:           load,
+		nodes:          make(map[int64]*hnswNode),
+		entry:          entry,
+		empty:          entry == 0,
+	}
+	b.node = func(id int64) (*hnswNode, error) {
+		if node, found := b.nodes[id]; found {
+			return node, nil
+		}
+		return b.load(id)
+	}
+	return b
+}
+
+// hnswLevel is the top layer of node id, exponentially distributed like a
+// random draw, from the splitmix64 hash of the ID.
+func hnswLevel(id int64, levelMult float64) int {
+	x := uint64(id) + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return int(-math.Log(1-float64(x>>11)/(1<<53)) * levelMult)
+}
+
+// modified returns node id for a change, copied into nodes the first time so
+// that the loaded one, possibly cached, stays untouched.
+func (b *hnswBuilder) modified(id int64) (*hnswNode, error) {
+	if node, found := b.nodes[id]; found {
+		return node, nil
+	}
+	loaded, err := b.load(id)
+	if err != nil {
+		return nil, err
+	}
+	node := &hnswNode{embedding: loaded.embedding, neighbors: make([][]int64, len(loaded.neighbors))}
+	for layer, neighbors := range loaded.neighbors {
+		node.neighbors[layer] = slices.Clone(neighbors)
+	}
+	b.nodes[id] = node
+	return node, nil
+}
+
+func (b *hnswBuilder) insert(id int64, embedding []float32) error {
+	level := hnswLevel(id, b.levelMult)
+	node := &hnswNode{embedding: embedding, neighbors: make([][]int64, level+1)}
+
+	if b.empty {
+		b.nodes[id] = node
+		b.entry = id
+		b.empty = false
+		return nil
+	}
+
+	entry, err := b.node(b.entry)
+	if err != nil {
+		return err
+	}
+	if len(entry.embedding) != len(embedding) {
+		return fmt.Errorf("vector %d has %d dimensions instead of %d", id, len(embedding), len(entry.embedding))
+	}
+	b.nodes[id] = node
+
+	top := len(entry.neighbors) - 1
+	closest := []VectorDistance{{ID: b.entry, Distance: hnswDistance(embedding, entry.embedding)}}
+	for layer := top; layer > level; layer-- {
+		if closest, err = b.searchLayer(embedding, closest, 1, layer); err != nil {
+			return err
+		}
+	}
+
+	for layer := min(level, top); layer >= 0; layer-- {
+		if closest, err = b.searchLayer(embedding, closest, b.efConstruction, layer); err != nil {
+			return err
+		}
+		if node.neighbors[layer], err = b.selectNeighbors(closest, b.m); err != nil {
+			return err
+		}
+
+		maxNeighbors := b.m
+		if layer == 0 {
+			maxNeighbors = 2 * b.m
+		}
+		for _, neighborID := range node.neighbors[layer] {
+			neighbor, err := b.modified(neighborID)
+			if err != nil {
+				return err
+			}
+			neighbor.neighbors[layer] = append(neighbor.neighbors[layer], id)
+			if len(neighbor.neighbors[layer]) <= maxNeighbors {
+				continue
+			}
+			links := make([]VectorDistance, len(neighbor.neighbors[layer]))
+			for i, linkID := range neighbor.neighbors[layer] {
+				link, err := b.node(linkID)
+				if err != nil {
+					return err
+				}
+				links[i] = VectorDistance{ID: linkID, Distance: hnswDistance(neighbor.embedding, link.embedding)}
+			}
+			sort.Slice(links, func(i, j int) bool {
+				return links[i].Distance < links[j].Distance
+			})
+			if neighbor.neighbors[layer], err = b.selectNeighbors(links, maxNeighbors); err != nil {
+				return err
+			}
+		}
+	}
+
+	if level > top {
+		b.entry = id
+	}
+	return nil
+}
+
+// hnswNeighborsEncode stores the neighbours of every layer as a little endian
+// uint32 count followed by the int64 node IDs.
+func hnswNeighborsEncode(neighbors [][]int64) []byte {
+	size := 0
+	for _, layer := range neighbors {
+		size += 4 + 8*len(layer)
+	}
+	data := make([]byte, 0, size)
+	for _, layer := range neighbors {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(layer)))
+		for _, id := range layer {
+			data = binary.LittleEndian.AppendUint64(data, uint64(id))
+		}
+	}
+	return data
+}
+
+func hnswNeighborsDecode(data []byte) ([][]int64, error) {
+	var neighbors [][]int64
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("invalid HNSW neighbours")
+		}
+		count := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if len(data) < count*8 {
+			return nil, fmt.Errorf("invalid HNSW neighbours")
+		}
+		layer := make([]int64, count)
+		for i := range layer {
+			layer[i] = int64(binary.LittleEndian.Uint64(data[i*8:]))
+		}
+		neighbors = append(neighbors, layer)
+		data = data[count*8:]
+	}
+	return neighbors, nil
+}
@@ -0,0 +1,197 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// annTestVectors returns count seeded random vectors of dim dimensions,
+// spread around clusters like real embeddings.
+func annTestVectors(seed int64, count int, dim int) [][]float32 {
+	r := rand.New(rand.NewSource(seed))
+	centers := make([][]float32, 16)
+	for i := range centers {
+		centers[i] = make([]float32, dim)
+		for j := range centers[i] {
+			centers[i][j] = float32(r.NormFloat64())
+		}
+	}
+	vectors := make([][]float32, count)
+	for i := range vectors {
+		center := centers[r.Intn(len(centers))]
+		vectors[i] = make([]float32, dim)
+		for j := range vectors[i] {
+			vectors[i][j] = center[j] + float32(r.NormFloat64())*0.5
+		}
+	}
+	return vectors
+}
+
+// annTestNearest returns the IDs, from 1, of the k vectors closest to query.
+func annTestNearest(vectors [][]float32, query []float32, k int) []int64 {
+	ids := make([]int64, len(vectors))
+	for i := range ids {
+		ids[i] = int64(i + 1)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return hnswDistance(query, vectors[ids[i]-1]) < hnswDistance(query, vectors[ids[j]-1])
+	})
+	return ids[:k]
+}
+
+// annTestRecall returns the share of the k nearest vectors to every query
+// found by search.
+func annTestRecall(t *testing.T, vectors [][]float32, queries [][]float32, k int, search func(query []float32) ([]int64, error)) float64 {
+	found := 0
+	for _, query := range queries {
+		ids, err := search(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[int64]bool)
+		for _, id := range ids {
+			got[id] = true
+		}
+		for _, id := range annTestNearest(vectors, query, k) {
+			if got[id] {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(len(queries)*k)
+}
+
+func TestHNSWRecall(t *testing.T) {
+	vectors := annTestVectors(1, 2000, 32)
+	queries := annTestVectors(2, 50, 32)
+	tests := []struct {
+		m, efConstruction, ef int
+		want                  float64
+	}{
+		{4, 20, 10, 0.3},
+		{8, 100, 64, 0.9},
+		{16, 200, 128, 0.97},
+	}
+	for _, test := range tests {
+		builder := newHNSWBuilder(test.m, test.efConstruction, func(id int64) (*hnswNode, error) {
+			return nil, fmt.Errorf("HNSW node %d not found", id)
+		}, 0)
+		for i, vector := range vectors {
+			if err := builder.insert(int64(i+1), vector); err != nil {
+				t.Fatal(err)
+			}
+		}
+		recall := annTestRecall(t, vectors, queries, 10, func(query []float32) ([]int64, error) {
+			results, err := builder.search(query, builder.entry, 10, test.ef)
+			var ids []int64
+			for _, result := range results {
+				ids = append(ids, result.ID)
+			}
+			return ids, err
+		})
+		if recall < test.want {
+			t.Errorf("M %d, efConstruction %d, ef %d: recall@10 %.3f, want at least %.2f", test.m, test.efConstruction, test.ef, recall, test.want)
+		}
+	}
+}
+
+func TestHNSWLevel(t *testing.T) {
+	levelMult := 1 / 2.772588722239781
+	levels := make(map[int]int)
+	for id := int64(1); id <= 100000; id++ {
+		level := hnswLevel(id, levelMult)
+		if level != hnswLevel(id, levelMult) {
+			t.Fatalf("level of %d not deterministic", id)
+		}
+		levels[level]++
+	}
+	if share := float64(levels[0]) / 100000; share < 0.9 || share > 0.96 {
+		t.Errorf("share of the nodes only on layer 0 %.3f, want about 1-1/M", share)
+	}
+	if levels[1] == 0 || levels[2] == 0 {
+		t.Errorf("levels %v, want upper layers", levels)
+	}
+}
+
+func TestHNSWNeighbors(t *testing.T) {
+	tests := [][][]int64{
+		nil,
+		{{}},
+		{{1, 2, 3}},
+		{{1, 2, 3, 4}, {2, 4}, {}},
+		{{-1, 1 << 40}},
+	}
+	for _, neighbors := range tests {
+		got, err := hnswNeighborsDecode(hnswNeighborsEncode(neighbors))
+		if err != nil {
+			t.Errorf("%v: %v", neighbors, err)
+			continue
+		}
+		if len(neighbors) == 0 && len(got) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, neighbors) {
+			t.Errorf("round trip of %v gave %v", neighbors, got)
+		}
+	}
+
+	for _, data := range [][]byte{{1}, {1, 0, 0, 0}, {2, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0}} {
+		if _, err := hnswNeighborsDecode(data); err == nil {
+			t.Errorf("%v decoded", data)
+		}
+	}
+}
+
+func TestProcessHNSW(t *testing.T) {
+	ctx := context.Background()
+	vectors := annTestVectors(1, 1500, 16)
+	queries := annTestVectors(2, 30, 16)
+
+	for _, cacheSize := range []int{0, 100} {
+		e := testOpenVectors(t, Config{AiAnnMode: "hnsw", CacheSize: cacheSize}, vectors[:1000])
+		for _, count := range []int{1000, 1500} {
+			if count > 1000 {
+				testAddVectors(t, e, vectors[1000:])
+			}
+			if err := e.db.write(func() error { return e.db.ProcessHNSW(ctx) }); err != nil {
+				t.Fatal(err)
+			}
+			var nodes int
+			if err := e.db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM vectors_hnsw").Scan(&nodes); err != nil {
+				t.Fatal(err)
+			}
+			if nodes != count {
+				t.Errorf("cache %d: %d nodes, want %d", cacheSize, nodes, count)
+			}
+			recall := annTestRecall(t, NormalizeVectors(vectors[:count]), NormalizeVectors(queries), 10, func(query []float32) ([]int64, error) {
+				results, err := e.db.SearchHNSW(ctx, query, 0, 10)
+				var ids []int64
+				for _, result := range results {
+					ids = append(ids, result.ID)
+				}
+				return ids, err
+			})
+			if recall < 0.95 {
+				t.Errorf("cache %d, %d vectors: recall@10 %.3f", cacheSize, count, recall)
+			}
+		}
+
+		e.db.config.AiAnnM = 8
+		if err := e.db.write(func() error { return e.db.ProcessHNSW(ctx) }); err != nil {
+			t.Fatal(err)
+		}
+		var nodes int
+		if err := e.db.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM vectors_hnsw").Scan(&nodes); err != nil {
+			t.Fatal(err)
+		}
+		if m, _ := e.db.SetupGet(ctx, "annM"); m != "8" || nodes != len(vectors) {
+			t.Errorf("cache %d: M %s with %d nodes after changing M, want 8 with %d", cacheSize, m, nodes, len(vectors))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+)
+
+const (
+	ivfProbe        = 8
+	ivfIterations   = 20
+	ivfTrainPerList = 64
+)
+
+// ivfLists returns the number of lists for total vectors, the requested one
+// or the square root of total, so that every list holds about as many vectors
+// as there are lists.
+func ivfLists(requested int, total int) int {
+	lists := requested
+	if lists <= 0 {
+		lists = int(math.Sqrt(float64(total)))
+	}
+	return max(1, min(lists, total))
+}
+
+// ivfNearest returns the index of the centroid closest to vector.
+func ivfNearest(centroids [][]float32, vector []float32) int {
+	nearest := 0
+	best := float32(math.MaxFloat32)
+	for i, centroid := range centroids {
+		if distance := hnswDistance(vector, centroid); distance < best {
+			best = distance
+			nearest = i
+		}
+	}
+	return nearest
+}
+
+// ivfAssign returns the nearest centroid of every vector, spreading the work
+// over all the CPUs.
+func ivfAssign(centroids [][]float32, vectors [][]float32) []int {
+	assignments := make([]int, len(vectors))
+	workers := runtime.NumCPU()
+	step := (len(vectors) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(vectors); start += step {
+		end := min(start+step, len(vectors))
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				assignments[i] = ivfNearest(centroids, vectors[i])
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	return assignments
+}
+
+// ivfTrain runs k-means over vectors, starting from lists of them drawn with
+// a fixed seed so that the same vectors always give the same centroids. A
+// list left empty is moved to a random vector.
+func ivfTrain(vectors [][]float32, lists int, iterations int, progress func(iteration int, moved int)) [][]float32 {
+	random := rand.New(rand.NewSource(1))
+	dim := len(vectors[0])
+	centroids := make([][]float32, lists)
+	for i, index := range random.Perm(len(vectors))[:lists] {
+		centroids[i] = append([]float32(nil), vectors[index]...)
+	}
+
+	assignments := make([]int, len(vectors))
+	for i := range assignments {
+		assignments[i] = -1
+	}
+	for iteration := 1; iteration <= iterations; iteration++ {
+		moved := 0
+		for i, list := range ivfAssign(centroids, vectors) {
+			if assignments[i] != list {
+				assignments[i] = list
+				moved++
+			}
+		}
+		if progress != nil {
+			progress(iteration, moved)
+		}
+		if moved == 0 {
+			break
+		}
+
+		sums := make([][]float64, lists)
+		counts := make([]int, lists)
+		for i := range sums {
+			sums[i] = make([]float64, dim)
+		}
+		for i, list := range assignments {
+			counts[list]++
+			for j, value := range vectors[i] {
+				sums[list][j] += float64(value)
+			}
+		}
+		for i := range centroids {
+			if counts[i] == 0 {
+				copy(centroids[i], vectors[random.Intn(len(vectors))])
+				continue
+			}
+			for j := range centroids[i] {
+				centroids[i][j] = float32(sums[i][j] / float64(counts[i]))
+			}
+		}
+	}
+	return centroids
+}
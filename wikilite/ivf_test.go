@@ -0,0 +1,137 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// ivfTestInertia returns the sum of the squared distances of the vectors to
+// their nearest centroid.
+func ivfTestInertia(centroids [][]float32, vectors [][]float32) float64 {
+	var inertia float64
+	for _, vector := range vectors {
+		inertia += float64(hnswDistance(vector, centroids[ivfNearest(centroids, vector)]))
+	}
+	return inertia
+}
+
+func TestIVFLists(t *testing.T) {
+	tests := []struct {
+		requested, total, want int
+	}{
+		{0, 10000, 100},
+		{0, 3, 1},
+		{0, 0, 1},
+		{50, 10000, 50},
+		{50, 20, 20},
+	}
+	for _, test := range tests {
+		if got := ivfLists(test.requested, test.total); got != test.want {
+			t.Errorf("ivfLists(%d, %d) = %d, want %d", test.requested, test.total, got, test.want)
+		}
+	}
+}
+
+func TestIVFTrain(t *testing.T) {
+	vectors := annTestVectors(1, 2000, 16)
+
+	start := ivfTestInertia(ivfTrain(vectors, 16, 0, nil), vectors)
+	var moves []int
+	centroids := ivfTrain(vectors, 16, ivfIterations, func(iteration int, moved int) {
+		moves = append(moves, moved)
+	})
+	if len(centroids) != 16 || len(centroids[0]) != 16 {
+		t.Fatalf("%d centroids of %d dimensions, want 16 of 16", len(centroids), len(centroids[0]))
+	}
+	if trained := ivfTestInertia(centroids, vectors); trained >= start*0.9 {
+		t.Errorf("inertia %.1f after training, %.1f before", trained, start)
+	}
+	if moves[0] != len(vectors) || moves[len(moves)-1] >= moves[0] {
+		t.Errorf("moved vectors %v, want all then fewer", moves)
+	}
+	if again := ivfTrain(vectors, 16, ivfIterations, nil); !reflect.DeepEqual(again, centroids) {
+		t.Errorf("training not deterministic")
+	}
+
+	duplicates := [][]float32{{1, 1}, {1, 1}, {1, 1}, {2, 2}}
+	for _, centroid := range ivfTrain(duplicates, 3, ivfIterations, nil) {
+		if ivfTestInertia([][]float32{centroid}, [][]float32{{1, 1}})*ivfTestInertia([][]float32{centroid}, [][]float32{{2, 2}}) != 0 {
+			t.Errorf("centroid %v away from the vectors, empty lists must move to one", centroid)
+		}
+	}
+}
+
+func TestSearchIVF(t *testing.T) {
+	ctx := context.Background()
+	vectors := annTestVectors(1, 2000, 16)
+	queries := annTestVectors(2, 30, 16)
+	e := testOpenVectors(t, Config{AiAnnMode: "ivf", AiAnnLists: 40}, vectors)
+	if err := e.db.write(func() error { return e.db.ProcessANN(ctx) }); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		probe int
+		want  float64
+	}{
+		{1, 0.3},
+		{8, 0.9},
+		{40, 1},
+	}
+	for _, test := range tests {
+		e.db.config.AiAnnProbe = test.probe
+		recall := annTestRecall(t, NormalizeVectors(vectors), NormalizeVectors(queries), 10, func(query []float32) ([]int64, error) {
+			results, err := e.db.SearchIVF(ctx, query, 0, 10)
+			if err != nil {
+				return nil, err
+			}
+			var ids []int64
+			for _, result := range results {
+				id, err := e.db.annID(ctx, result)
+				if err != nil {
+					return nil, err
+				}
+				ids = append(ids, id)
+			}
+			return ids, nil
+		})
+		if recall < test.want {
+			t.Errorf("probe %d: recall@10 %.3f, want at least %.2f", test.probe, recall, test.want)
+		}
+	}
+}
+
+func TestAnnCodebook(t *testing.T) {
+	ctx := context.Background()
+	for _, cacheSize := range []int{0, 10} {
+		e := testOpenVectors(t, Config{CacheSize: cacheSize}, nil)
+		for _, codebook := range [][][]float32{
+			{{1, 2, 3}, {4, 5, 6}},
+			{{-1, 0.5}, {2, 3}, {0, 0}},
+		} {
+			if err := e.db.write(func() error { return e.db.annCodebookPut(ctx, "annCentroids", codebook) }); err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.db.annCodebook(ctx, "annCentroids", len(codebook))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, codebook) {
+				t.Errorf("cache %d: codebook %v, want %v", cacheSize, got, codebook)
+			}
+		}
+		if _, err := e.db.annCodebook(ctx, "annCodebooks", 3); err == nil {
+			t.Errorf("cache %d: missing codebook read", cacheSize)
+		}
+		e.db.codebooks.Purge()
+		if _, err := e.db.annCodebook(ctx, "annCentroids", 4); err == nil {
+			t.Errorf("cache %d: codebook read with the wrong count", cacheSize)
+		}
+		if stats := e.db.codebooks.Stats(); stats.Capacity != min(cacheSize, annCodebooks) {
+			t.Errorf("cache %d: codebooks capacity %d", cacheSize, stats.Capacity)
+		}
+	}
+}
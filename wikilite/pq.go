@@ -0,0 +1,77 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import "fmt"
+
+const (
+	pqCodes         = 256
+	pqSubvectorSize = 8
+	pqTrainVectors  = 32768
+)
+
+// pqSubvectors returns the number of sub-vectors a vector of dim dimensions
+// is split into, the requested one or one every pqSubvectorSize dimensions.
+func pqSubvectors(requested int, dim int) (int, error) {
+	subvectors := requested
+	if subvectors <= 0 {
+		subvectors = max(1, dim/pqSubvectorSize)
+	}
+	if subvectors > dim || dim%subvectors != 0 {
+		return 0, fmt.Errorf("%d dimensions cannot be split in %d sub-vectors", dim, subvectors)
+	}
+	return subvectors, nil
+}
+
+// pqTrain runs k-means over every slice of the vectors, returning the
+// centroids of the first sub-vector followed by the ones of the next.
+func pqTrain(vectors [][]float32, subvectors int, progress func(subvector int)) [][]float32 {
+	dim := len(vectors[0]) / subvectors
+	codes := min(pqCodes, len(vectors))
+	var codebook [][]float32
+	for s := 0; s < subvectors; s++ {
+		slices := make([][]float32, len(vectors))
+		for i, vector := range vectors {
+			slices[i] = vector[s*dim : (s+1)*dim]
+		}
+		codebook = append(codebook, ivfTrain(slices, codes, ivfIterations, nil)...)
+		if progress != nil {
+			progress(s + 1)
+		}
+	}
+	return codebook
+}
+
+// pqEncode returns for every sub-vector of vector the byte index of its
+// closest centroid.
+func pqEncode(codebook [][]float32, subvectors int, vector []float32) []byte {
+	dim := len(vector) / subvectors
+	codes := len(codebook) / subvectors
+	code := make([]byte, subvectors)
+	for s := range code {
+		code[s] = byte(ivfNearest(codebook[s*codes:(s+1)*codes], vector[s*dim:(s+1)*dim]))
+	}
+	return code
+}
+
+// pqTable returns the squared distances between every sub-vector of query
+// and the centroids of its slice, laid out like the codebook, so that the
+// distance of a code is the sum of one entry per sub-vector.
+func pqTable(codebook [][]float32, subvectors int, query []float32) []float32 {
+	dim := len(query) / subvectors
+	codes := len(codebook) / subvectors
+	table := make([]float32, len(codebook))
+	for i, centroid := range codebook {
+		s := i / codes
+		table[i] = hnswDistance(query[s*dim:(s+1)*dim], centroid)
+	}
+	return table
+}
+
+func pqDistance(table []float32, codes int, code []byte) float32 {
+	var sum float32
+	for s, c := range code {
+		sum += table[s*codes+int(c)]
+	}
+	return sum
+}
@@ -0,0 +1,189 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestPQSubvectors(t *testing.T) {
+	tests := []struct {
+		requested, dim, want int
+		fail                 bool
+	}{
+		{0, 768, 96, false},
+		{0, 4, 1, false},
+		{48, 768, 48, false},
+		{5, 768, 0, true},
+		{16, 8, 0, true},
+	}
+	for _, test := range tests {
+		got, err := pqSubvectors(test.requested, test.dim)
+		if (err != nil) != test.fail || got != test.want {
+			t.Errorf("pqSubvectors(%d, %d) = %d, %v, want %d", test.requested, test.dim, got, err, test.want)
+		}
+	}
+}
+
+func TestPQEncode(t *testing.T) {
+	codebook := [][]float32{{0, 0}, {1, 1}, {0, 0}, {-1, 2}}
+	tests := []struct {
+		vector []float32
+		want   []byte
+	}{
+		{[]float32{0.1, 0, 0, 0}, []byte{0, 0}},
+		{[]float32{0.9, 1.2, -1, 1.5}, []byte{1, 1}},
+		{[]float32{2, 2, 0.1, -0.1}, []byte{1, 0}},
+	}
+	for _, test := range tests {
+		if got := pqEncode(codebook, 2, test.vector); string(got) != string(test.want) {
+			t.Errorf("pqEncode(%v) = %v, want %v", test.vector, got, test.want)
+		}
+	}
+}
+
+func TestPQDistance(t *testing.T) {
+	vectors := annTestVectors(1, 1000, 16)
+	queries := annTestVectors(2, 20, 16)
+	codebook := pqTrain(vectors, 4, nil)
+	codes := len(codebook) / 4
+	if codes != pqCodes {
+		t.Fatalf("%d codes per sub-vector, want %d", codes, pqCodes)
+	}
+
+	for _, query := range queries {
+		table := pqTable(codebook, 4, query)
+		for _, vector := range vectors[:100] {
+			code := pqEncode(codebook, 4, vector)
+			var decoded []float32
+			for s, c := range code {
+				decoded = append(decoded, codebook[s*codes+int(c)]...)
+			}
+			got, want := pqDistance(table, codes, code), hnswDistance(query, decoded)
+			if math.Abs(float64(got-want)) > 1e-3*float64(want) {
+				t.Fatalf("table distance %f, want %f", got, want)
+			}
+		}
+	}
+}
+
+func TestAnnScan(t *testing.T) {
+	ctx := context.Background()
+	e := testOpenVectors(t, Config{}, nil)
+	chunks := [][]float32{{5, 1, 3}, {4, 0, 2, 8}}
+	if err := e.db.write(func() error {
+		for i, chunk := range chunks {
+			data := Float32ToBytes(chunk)
+			if i == 0 {
+				data = append(data, 1, 2)
+			}
+			if _, err := e.db.db.ExecContext(ctx, "INSERT INTO vectors_ann_chunks (id, chunk) VALUES (?, ?)", i+1, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var all []VectorDistance
+	for i, chunk := range chunks {
+		for position, value := range chunk {
+			all = append(all, VectorDistance{ChunkRowID: int64(i + 1), ChunkPosition: position, Distance: float32(math.Sqrt(float64(value)))})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Distance < all[j].Distance })
+
+	for _, limit := range []int{1, 3, len(all), len(all) + 5} {
+		rows, err := e.db.db.QueryContext(ctx, "SELECT id, chunk FROM vectors_ann_chunks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := annScan(rows, 4, limit, func(vector []byte) float32 {
+			return BytesToFloat32(vector)[0]
+		})
+		rows.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := all[:min(limit, len(all))]
+		if len(got) != len(want) {
+			t.Fatalf("limit %d: %v, want %v", limit, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("limit %d: %v, want %v", limit, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchPQ(t *testing.T) {
+	ctx := context.Background()
+	vectors := annTestVectors(1, 2000, 64)
+	queries := annTestVectors(2, 30, 64)
+
+	recalls := make(map[string]float64)
+	for _, mode := range []string{"pq", "binary"} {
+		e := testOpenVectors(t, Config{AiAnnMode: mode}, vectors)
+		if err := e.db.write(func() error { return e.db.ProcessANN(ctx) }); err != nil {
+			t.Fatal(err)
+		}
+		recalls[mode] = annTestRecall(t, NormalizeVectors(vectors), NormalizeVectors(queries), 10, func(query []float32) ([]int64, error) {
+			results, err := e.db.SearchAnn(ctx, query, mode, 0, 10)
+			if err != nil {
+				return nil, err
+			}
+			var ids []int64
+			for _, result := range results {
+				id, err := e.db.annID(ctx, result)
+				if err != nil {
+					return nil, err
+				}
+				ids = append(ids, id)
+			}
+			return ids, nil
+		})
+	}
+	if recalls["pq"] < 0.3 || recalls["pq"] <= recalls["binary"] {
+		t.Errorf("recall@10 %v, want pq above 0.3 and binary codes of the same size", recalls)
+	}
+}
+
+func TestVectorsDrop(t *testing.T) {
+	ctx := context.Background()
+	e := testOpenVectors(t, Config{AiAnnMode: "pq"}, annTestVectors(1, 300, 16))
+	if err := e.db.write(func() error { return e.db.ProcessRelated(ctx) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ProcessANN(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.VectorsDrop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if e.db.AiHasVectors(ctx) || !e.db.AiHasANN(ctx) {
+		t.Fatalf("vectors or ANN index left after the drop")
+	}
+	if stats, err := e.db.Stats(ctx); err != nil || stats.EmbeddingDimension != 16 {
+		t.Errorf("embedding dimension %d after the drop, %v, want 16", stats.EmbeddingDimension, err)
+	}
+
+	e.db.config.AiAnnMode = "binary"
+	for name, process := range map[string]func() error{
+		"embeddings": func() error { return e.db.ProcessEmbeddings(ctx) },
+		"ann":        func() error { return e.db.ProcessANN(ctx) },
+		"related":    func() error { return e.db.ProcessRelated(ctx) },
+	} {
+		if err := e.db.write(process); err != ErrVectorsDropped {
+			t.Errorf("%s: %v, want ErrVectorsDropped", name, err)
+		}
+	}
+	if !e.db.AiHasANN(ctx) {
+		t.Errorf("ANN index dropped")
+	}
+}
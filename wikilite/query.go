@@ -0,0 +1,251 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// QueryRawPrefix marks a query to be passed to FTS5 MATCH untouched.
+const QueryRawPrefix = "fts:"
+
+const queryNearDistance = 10
+
+type queryTerm struct {
+	field    string
+	text     string
+	prefix   bool
+	negate   bool
+	or       bool
+	near     bool
+	distance int
+}
+
+// QueryFTS translates a user query into a valid FTS5 MATCH expression.
+// Words and "quoted phrases" are all required, while -word excludes, OR
+// joins alternatives, word* matches a prefix, field:word restricts to one of
+// the given columns and a NEAR/n b matches words at most n tokens apart.
+// An empty result means the query has nothing to search for.
+func QueryFTS(input string, columns ...string) string {
+	return queryFTS(input, nil, nil, columns)
+}
+
+// queryFTS is QueryFTS with every word but prefixes passed through stem, for
+// indexes holding stemmed text. Single words found in expansions, by their
+// lowercase form, match any of the index terms listed there as well.
+func queryFTS(input string, stem func(string) string, expansions map[string][]string, columns []string) string {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(input, QueryRawPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(input, QueryRawPrefix))
+	}
+
+	var groups [][]string
+	var group []string
+	var negatives []string
+
+	terms := queryParse(input)
+	alternatives := make([][]string, len(terms))
+	for i := range terms {
+		if !terms[i].prefix && !strings.ContainsFunc(terms[i].text, unicode.IsSpace) {
+			alternatives[i] = expansions[strings.ToLower(terms[i].text)]
+		}
+		if stem != nil && !terms[i].prefix {
+			terms[i].text = stem(terms[i].text)
+		}
+	}
+	for i := 0; i < len(terms); i++ {
+		term := terms[i]
+		if term.negate {
+			negatives = append(negatives, queryTermFTS(term, columns))
+			continue
+		}
+
+		if term.or && len(group) > 0 {
+			groups = append(groups, group)
+			group = nil
+		}
+
+		if i+1 < len(terms) && terms[i+1].near && !terms[i+1].negate {
+			phrases := []string{queryPhrase(term.text, term.prefix)}
+			distance := 0
+			for i+1 < len(terms) && terms[i+1].near && !terms[i+1].negate {
+				i++
+				phrases = append(phrases, queryPhrase(terms[i].text, terms[i].prefix))
+				distance = max(distance, terms[i].distance)
+			}
+			group = append(group, "NEAR("+strings.Join(phrases, " ")+", "+strconv.Itoa(distance)+")")
+			continue
+		}
+
+		if len(alternatives[i]) > 0 {
+			phrases := []string{queryTermFTS(term, columns)}
+			for _, alternative := range alternatives[i] {
+				term.text = alternative
+				phrases = append(phrases, queryTermFTS(term, columns))
+			}
+			group = append(group, "("+strings.Join(phrases, " OR ")+")")
+			continue
+		}
+
+		group = append(group, queryTermFTS(term, columns))
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+
+	if len(groups) == 0 {
+		return ""
+	}
+
+	clauses := make([]string, len(groups))
+	for i, group := range groups {
+		clauses[i] = strings.Join(group, " AND ")
+	}
+	match := strings.Join(clauses, " OR ")
+
+	if len(negatives) > 0 {
+		match = "(" + match + ") NOT (" + strings.Join(negatives, " OR ") + ")"
+	}
+
+	return match
+}
+
+// QueryText returns the searchable words of a query without any operator,
+// suitable for embeddings.
+func QueryText(input string) string {
+	input = strings.TrimSpace(input)
+	if strings.HasPrefix(input, QueryRawPrefix) {
+		return strings.TrimSpace(strings.TrimPrefix(input, QueryRawPrefix))
+	}
+
+	var words []string
+	for _, term := range queryParse(input) {
+		if !term.negate {
+			words = append(words, term.text)
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+func queryParse(input string) []queryTerm {
+	var terms []queryTerm
+	var pending queryTerm
+
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		term := pending
+		pending = queryTerm{}
+
+		if runes[i] == '-' {
+			term.negate = true
+			i++
+		}
+
+		field := i
+		for field < len(runes) && unicode.IsLetter(runes[field]) {
+			field++
+		}
+		if field > i && field+1 < len(runes) && runes[field] == ':' && !unicode.IsSpace(runes[field+1]) {
+			term.field = strings.ToLower(string(runes[i:field]))
+			i = field + 1
+		}
+
+		quoted := false
+		start := i
+		if i < len(runes) && runes[i] == '"' {
+			quoted = true
+			start = i + 1
+			i = start
+			for i < len(runes) && runes[i] != '"' {
+				i++
+			}
+			term.text = string(runes[start:i])
+			for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+				if runes[i] == '*' {
+					term.prefix = true
+				}
+			}
+		} else {
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			term.text = string(runes[start:i])
+			if strings.HasSuffix(term.text, "*") {
+				term.prefix = true
+				term.text = strings.TrimRight(term.text, "*")
+			}
+		}
+
+		if !quoted && !term.negate && term.field == "" && !term.prefix {
+			switch {
+			case term.text == "OR":
+				pending = term
+				pending.text = ""
+				pending.or = true
+				continue
+			case term.text == "AND":
+				pending = term
+				pending.text = ""
+				continue
+			case term.text == "NOT":
+				pending = term
+				pending.text = ""
+				pending.negate = true
+				continue
+			case term.text == "NEAR" || strings.HasPrefix(term.text, "NEAR/"):
+				pending = term
+				pending.text = ""
+				pending.near = true
+				pending.distance = queryNearDistance
+				if distance, err := strconv.Atoi(strings.TrimPrefix(term.text, "NEAR/")); err == nil && distance > 0 {
+					pending.distance = distance
+				}
+				continue
+			}
+		}
+
+		if queryHasWord(term.text) {
+			terms = append(terms, term)
+		}
+	}
+
+	return terms
+}
+
+func queryTermFTS(term queryTerm, columns []string) string {
+	phrase := queryPhrase(term.text, term.prefix)
+	for _, column := range columns {
+		if term.field == column {
+			return column + " : " + phrase
+		}
+	}
+	if term.field != "" {
+		return queryPhrase(term.field+" "+term.text, term.prefix)
+	}
+	return phrase
+}
+
+func queryPhrase(text string, prefix bool) string {
+	phrase := `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+	if prefix {
+		phrase += "*"
+	}
+	return phrase
+}
+
+func queryHasWord(text string) bool {
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
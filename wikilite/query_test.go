@@ -0,0 +1,122 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQueryFTS(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"linux kernel", `"linux" AND "kernel"`},
+		{`"linux kernel" history`, `"linux kernel" AND "history"`},
+		{"linux OR unix", `"linux" OR "unix"`},
+		{"x OR y z", `"x" OR "y" AND "z"`},
+		{"a AND b", `"a" AND "b"`},
+		{"linux -windows", `("linux") NOT ("windows")`},
+		{"linux NOT windows", `("linux") NOT ("windows")`},
+		{"kern*", `"kern"*`},
+		{`"linux ker"*`, `"linux ker"*`},
+		{"title:linux kernel", `title : "linux" AND "kernel"`},
+		{`author:"linus torvalds"`, `"author linus torvalds"`},
+		{"linux NEAR/3 torvalds", `NEAR("linux" "torvalds", 3)`},
+		{"linux NEAR torvalds", `NEAR("linux" "torvalds", 10)`},
+		{`say "hi"`, `"say" AND "hi"`},
+		{`"q""uote"`, `"q"`},
+		{`it's`, `"it's"`},
+		{`a"b`, `"a""b"`},
+		{"- OR ( )", ""},
+		{"fts:linux OR x*", "linux OR x*"},
+	}
+	for _, test := range tests {
+		if got := QueryFTS(test.input, "title", "content"); got != test.want {
+			t.Errorf("QueryFTS(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+
+	db, err := sql.Open("sqlite3_wikilite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE VIRTUAL TABLE search USING fts5(title, content)"); err != nil {
+		t.Skipf("FTS5 not available: %v", err)
+	}
+	for _, test := range tests {
+		if test.want == "" {
+			continue
+		}
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM search WHERE search MATCH ?", test.want).Scan(&count); err != nil {
+			t.Errorf("MATCH %q for %q: %v", test.want, test.input, err)
+		}
+	}
+}
+
+func TestQueryFTSStemExpansions(t *testing.T) {
+	stem := func(text string) string {
+		return strings.ReplaceAll(strings.ToLower(text), "running", "run")
+	}
+	expansions := map[string][]string{"running": {"jogging"}}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Running fast*", `("run" OR "jogging") AND "fast"*`},
+		{`"running shoes"`, `"run shoes"`},
+		{"-running", ""},
+	}
+	for _, test := range tests {
+		if got := queryFTS(test.input, stem, expansions, nil); got != test.want {
+			t.Errorf("queryFTS(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestQueryText(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"linux OR unix", "linux unix"},
+		{`"linux kernel" -windows`, "linux kernel"},
+		{"title:linux NEAR/2 kern*", "linux kern"},
+		{"fts:linux OR x", "linux OR x"},
+	}
+	for _, test := range tests {
+		if got := QueryText(test.input); got != test.want {
+			t.Errorf("QueryText(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestQueryParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []queryTerm
+	}{
+		{"linux", []queryTerm{{text: "linux"}}},
+		{"a OR b", []queryTerm{{text: "a"}, {text: "b", or: true}}},
+		{"a NOT b", []queryTerm{{text: "a"}, {text: "b", negate: true}}},
+		{"-title:b*", []queryTerm{{field: "title", text: "b", prefix: true, negate: true}}},
+		{"a NEAR/4 b", []queryTerm{{text: "a"}, {text: "b", near: true, distance: 4}}},
+		{"a NEAR/x b", []queryTerm{{text: "a"}, {text: "b", near: true, distance: queryNearDistance}}},
+		{`"OR" "-x"`, []queryTerm{{text: "OR"}, {text: "-x"}}},
+		{"title: x", []queryTerm{{text: "title:"}, {text: "x"}}},
+		{"* - ...", nil},
+	}
+	for _, test := range tests {
+		if got := queryParse(test.input); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("queryParse(%q) = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+}
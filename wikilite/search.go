@@ -0,0 +1,577 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	SearchRetrieverTitle     = "title"
+	SearchRetrieverContent   = "content"
+	SearchRetrieverSemantic  = "semantic"
+	SearchRetrieverRerank    = "rerank"
+	SearchRetrieverExpansion = "expansion"
+)
+
+const (
+	searchRrfK         = 60
+	searchDepth        = 50
+	searchRerankLength = 2048
+
+	searchExpansionTerms      = 2
+	searchExpansionSimilarity = 0.75
+	searchWeightExpansion     = 0.5
+)
+
+// ErrSearchPartial is returned together with the results of a search cut
+// short by Config.SearchTimeout, fused from the retrievers done in time.
+var ErrSearchPartial = errors.New("search timeout, partial results")
+
+type searchRanking struct {
+	name    string
+	weight  float64
+	results []SearchResult
+}
+
+// searchTask is a retriever waiting to run, producing the ranking name.
+type searchTask struct {
+	name     string
+	weight   float64
+	retrieve func(ctx context.Context) ([]SearchResult, error)
+}
+
+// Search fuses the title, content and semantic rankings with reciprocal rank
+// fusion, weighted by Config.SearchWeight*, then reranks the top
+// Config.SearchRerank results when a rerank model is available. The
+// retrievers run concurrently, see searchRun for Config.SearchTimeout.
+func (e *Engine) Search(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	return e.searchCached(ctx, "search", query, options, e.search)
+}
+
+func (e *Engine) SearchSemantic(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	return e.searchCached(ctx, "semantic", query, options, e.searchSemantic)
+}
+
+func (e *Engine) SearchLexical(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	return e.searchCached(ctx, "lexical", query, options, e.searchLexical)
+}
+
+func (e *Engine) SearchTitle(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	return e.searchCached(ctx, "title", query, options, e.searchTitle)
+}
+
+// searchCached serves a search from the result cache, running search and
+// storing its results on a miss. The caches are dropped first if the
+// database changed meanwhile. Explained searches always run, partial results
+// are not stored.
+func (e *Engine) searchCached(ctx context.Context, mode string, query string, options SearchOptions, search func(context.Context, string, SearchOptions) ([]SearchResult, error)) ([]SearchResult, error) {
+	e.db.cacheValidate(ctx)
+	if options.Explain != nil {
+		return search(ctx, query, options)
+	}
+
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%d\x00%d\x00%t\x00%v\x00%s", mode, options.Limit, options.Offset, SearchDepth(options), options.Snippet, options.Expand, options.Filter, query)
+	if results, found := e.db.results.Get(key); found {
+		return searchCopy(results), nil
+	}
+
+	results, err := search(ctx, query, options)
+	if err != nil {
+		return results, err
+	}
+	e.db.results.Put(key, searchCopy(results))
+	return results, nil
+}
+
+// searchCopy copies results along with their Retrievers maps, so that the
+// cached ones are never shared with the caller.
+func searchCopy(results []SearchResult) []SearchResult {
+	copied := append([]SearchResult(nil), results...)
+	for i := range copied {
+		copied[i].Retrievers = maps.Clone(copied[i].Retrievers)
+	}
+	return copied
+}
+
+func (e *Engine) search(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	ctx = searchTraced(ctx, options.Explain, e.ID)
+	tasks := e.searchLexicalTasks(query, options)
+	if e.ai && e.db.config.SearchWeightSemantic > 0 && options.Filter.allows(SearchRetrieverSemantic) {
+		tasks = append(tasks, searchTask{SearchRetrieverSemantic, e.db.config.SearchWeightSemantic, func(ctx context.Context) ([]SearchResult, error) {
+			return e.db.SearchVectors(ctx, query, SearchDepth(options), options.Snippet, options.Filter)
+		}})
+	}
+
+	rankings, err := e.searchRun(ctx, tasks)
+	if err != nil && !errors.Is(err, ErrSearchPartial) {
+		return nil, err
+	}
+
+	if !e.rerank || err != nil {
+		return e.searchFuse(rankings, options), err
+	}
+
+	results := e.searchFuse(rankings, SearchOptions{Limit: SearchDepth(options), Explain: options.Explain})
+	start := time.Now()
+	if err := e.searchRerank(ctx, query, results); err != nil {
+		log.Printf("Search %s, keeping the fused ranking", err)
+		return searchPage(results, options), nil
+	}
+	searchStage(ctx, SearchRetrieverRerank, start)
+	return searchPage(results, options), nil
+}
+
+func (e *Engine) searchSemantic(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	if !e.ai || !options.Filter.allows(SearchRetrieverSemantic) {
+		return nil, nil
+	}
+
+	ctx = searchTraced(ctx, options.Explain, e.ID)
+	return e.searchSingle(ctx, options, searchTask{SearchRetrieverSemantic, 1, func(ctx context.Context) ([]SearchResult, error) {
+		return e.db.SearchVectors(ctx, query, SearchDepth(options), options.Snippet, options.Filter)
+	}})
+}
+
+func (e *Engine) searchLexical(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	ctx = searchTraced(ctx, options.Explain, e.ID)
+	rankings, err := e.searchRun(ctx, e.searchLexicalTasks(query, options))
+	if err != nil && !errors.Is(err, ErrSearchPartial) {
+		return nil, err
+	}
+
+	return e.searchFuse(rankings, options), err
+}
+
+func (e *Engine) searchTitle(ctx context.Context, query string, options SearchOptions) ([]SearchResult, error) {
+	if !options.Filter.allows(SearchRetrieverTitle) {
+		return nil, nil
+	}
+
+	ctx = searchTraced(ctx, options.Explain, e.ID)
+	return e.searchSingle(ctx, options, searchTask{SearchRetrieverTitle, 1, func(ctx context.Context) ([]SearchResult, error) {
+		return e.db.SearchTitle(ctx, query, SearchDepth(options), options.Snippet, options.Filter)
+	}})
+}
+
+// SearchCount returns the number of articles matching the query in their
+// title or content, counting at most limit when limit is above 0. It is
+// lexical only, semantic search has no such bound.
+func (e *Engine) SearchCount(ctx context.Context, query string, filter SearchFilter, limit int) (int, error) {
+	return e.db.SearchCount(ctx, query, filter, limit)
+}
+
+// SearchFacets counts the sections matching the query by heading and by
+// heading level, at most limit values per facet.
+func (e *Engine) SearchFacets(ctx context.Context, query string, filter SearchFilter, limit int) (map[string][]SearchFacet, error) {
+	return e.db.SearchFacets(ctx, query, filter, limit)
+}
+
+// SearchCorrect returns the query with every word missing from the
+// vocabulary replaced by the closest and most frequent known term, or an
+// empty string when there is nothing to correct.
+func (e *Engine) SearchCorrect(ctx context.Context, query string) (string, error) {
+	if strings.HasPrefix(strings.TrimSpace(query), QueryRawPrefix) || !e.db.VocabularyHasIndex(ctx) {
+		return "", nil
+	}
+
+	corrected := false
+	words := strings.Fields(query)
+	for i, word := range words {
+		if _, after, found := strings.Cut(word, ":"); found {
+			word = after
+		}
+		first := strings.IndexFunc(word, snippetIsWord)
+		last := strings.LastIndexFunc(word, snippetIsWord)
+		if first < 0 {
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(word[last:])
+		term := word[first : last+size]
+		switch term {
+		case "OR", "AND", "NOT", "NEAR":
+			continue
+		}
+		if !vocabularyIndexable(term) || strings.HasSuffix(word, "*") || e.db.VocabularyFrequency(ctx, strings.ToLower(term)) > 0 || e.db.SearchHasTerm(ctx, term) {
+			continue
+		}
+
+		matches, err := e.db.VocabularyFuzzy(ctx, term, 1)
+		if err != nil {
+			return "", err
+		}
+		if len(matches) == 0 || matches[0].Power > float64(searchCorrectDistance(term)) {
+			continue
+		}
+		words[i] = strings.Replace(words[i], term, matches[0].Text, 1)
+		corrected = true
+	}
+
+	if !corrected {
+		return "", nil
+	}
+	return strings.Join(words, " "), nil
+}
+
+// searchCorrectDistance is the largest edit distance accepted for a
+// correction, short words allow a single typo.
+func searchCorrectDistance(term string) int {
+	if utf8.RuneCountInString(term) <= 4 {
+		return 1
+	}
+	return 2
+}
+
+// Suggest completes a title prefix, most relevant articles first.
+func (e *Engine) Suggest(ctx context.Context, prefix string, limit int) ([]SearchResult, error) {
+	results, err := e.db.Suggest(ctx, prefix, limit)
+	for i := range results {
+		results[i].DB = e.ID
+	}
+	return results, err
+}
+
+func (e *Engine) SearchWordDistance(ctx context.Context, word string, limit int) ([]SearchResult, error) {
+	return e.db.SearchWordDistance(ctx, word, limit)
+}
+
+// SearchDepth is the number of hits fetched from every retriever and fused,
+// Depth when set, otherwise searchDepth or the page size when larger. It
+// does not depend on Offset, so that all the pages of a query are cut from
+// the same fused ranking, and pages past it are empty.
+func SearchDepth(options SearchOptions) int {
+	if options.Depth > 0 {
+		return options.Depth
+	}
+	return max(searchDepth, options.Limit)
+}
+
+func (e *Engine) searchLexicalTasks(query string, options SearchOptions) []searchTask {
+	var tasks []searchTask
+
+	if e.db.config.SearchWeightTitle > 0 && options.Filter.allows(SearchRetrieverTitle) {
+		tasks = append(tasks, searchTask{SearchRetrieverTitle, e.db.config.SearchWeightTitle, func(ctx context.Context) ([]SearchResult, error) {
+			return e.db.SearchTitle(ctx, query, SearchDepth(options), options.Snippet, options.Filter)
+		}})
+	}
+
+	if e.db.config.SearchWeightContent > 0 && options.Filter.allows(SearchRetrieverContent) {
+		tasks = append(tasks, searchTask{SearchRetrieverContent, e.db.config.SearchWeightContent, func(ctx context.Context) ([]SearchResult, error) {
+			return e.db.SearchContent(ctx, query, SearchDepth(options), options.Snippet, options.Filter)
+		}})
+	}
+
+	if options.Expand && e.ai && e.db.config.SearchWeightExpansion > 0 && options.Filter.allows(SearchRetrieverExpansion) {
+		tasks = append(tasks, searchTask{SearchRetrieverExpansion, e.db.config.SearchWeightExpansion, func(ctx context.Context) ([]SearchResult, error) {
+			expansions, err := e.searchExpansions(ctx, query)
+			if err != nil || len(expansions) == 0 {
+				return nil, err
+			}
+			return e.db.SearchContentExpanded(ctx, query, expansions, SearchDepth(options), options.Snippet, options.Filter)
+		}})
+	}
+
+	return tasks
+}
+
+// searchRun runs the retrievers concurrently and returns their rankings in
+// the order of tasks. With Config.SearchTimeout set, the retrievers still
+// running when it expires, or failing because of it, are cancelled and left
+// out, and ErrSearchPartial is returned along with the rankings done in
+// time. Any other error fails the search, as does a cancelled ctx, like a
+// client gone away.
+func (e *Engine) searchRun(ctx context.Context, tasks []searchTask) ([]searchRanking, error) {
+	var runCtx context.Context
+	var cancel context.CancelFunc
+	if e.db.config.SearchTimeout > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, e.db.config.SearchTimeout)
+	} else {
+		runCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	type searchOutcome struct {
+		index   int
+		results []SearchResult
+		err     error
+	}
+	outcomes := make(chan searchOutcome, len(tasks))
+	for i, task := range tasks {
+		go func() {
+			start := time.Now()
+			results, err := task.retrieve(runCtx)
+			searchStage(runCtx, task.name, start)
+			outcomes <- searchOutcome{i, results, err}
+		}()
+	}
+
+	rankings := make([]*searchRanking, len(tasks))
+	partial := false
+	collect := func(outcome searchOutcome) error {
+		if outcome.err != nil {
+			timeout := errors.Is(outcome.err, context.DeadlineExceeded) || errors.Is(outcome.err, context.Canceled)
+			if timeout && ctx.Err() == nil && runCtx.Err() != nil {
+				partial = true
+				return nil
+			}
+			return outcome.err
+		}
+		task := tasks[outcome.index]
+		rankings[outcome.index] = &searchRanking{task.name, task.weight, outcome.results}
+		return nil
+	}
+
+	pending := len(tasks)
+	for pending > 0 && runCtx.Err() == nil {
+		select {
+		case outcome := <-outcomes:
+			pending--
+			if err := collect(outcome); err != nil {
+				return nil, err
+			}
+		case <-runCtx.Done():
+		}
+	}
+	if pending > 0 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		for ready := true; ready && pending > 0; {
+			select {
+			case outcome := <-outcomes:
+				pending--
+				if err := collect(outcome); err != nil {
+					return nil, err
+				}
+			default:
+				ready = false
+			}
+		}
+		partial = partial || pending > 0
+	}
+
+	var completed []searchRanking
+	for _, ranking := range rankings {
+		if ranking != nil {
+			completed = append(completed, *ranking)
+		}
+	}
+	if partial {
+		return completed, ErrSearchPartial
+	}
+	return completed, nil
+}
+
+// searchSingle runs a single retriever within Config.SearchTimeout, its
+// ranking weighing 1.
+func (e *Engine) searchSingle(ctx context.Context, options SearchOptions, task searchTask) ([]SearchResult, error) {
+	rankings, err := e.searchRun(ctx, []searchTask{task})
+	if err != nil && !errors.Is(err, ErrSearchPartial) {
+		return nil, err
+	}
+	return e.searchFuse(rankings, options), err
+}
+
+// searchExpansions maps the lowercase single words of query, prefixes and
+// excluded words aside, to the searchExpansionTerms vocabulary terms nearest
+// to them in the embedding space.
+func (e *Engine) searchExpansions(ctx context.Context, query string) (map[string][]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(query), QueryRawPrefix) || !e.db.VocabularyHasVectors(ctx) {
+		return nil, nil
+	}
+
+	expansions := make(map[string][]string)
+	for _, term := range queryParse(query) {
+		word := strings.ToLower(term.text)
+		if term.negate || term.prefix || strings.ContainsFunc(word, unicode.IsSpace) || expansions[word] != nil {
+			continue
+		}
+		neighbors, err := e.db.VocabularyNeighbors(ctx, word, searchExpansionTerms, searchExpansionSimilarity)
+		if err != nil {
+			return nil, err
+		}
+		for _, neighbor := range neighbors {
+			expansions[word] = append(expansions[word], neighbor.Text)
+		}
+	}
+
+	return expansions, nil
+}
+
+// searchFuse merges rankings by article with reciprocal rank fusion, each
+// article scoring weight/(k+rank) in every ranking it appears in. Power holds
+// the fused value and Score the same value divided by the best one reachable,
+// so it ranges from 0 to 1 whatever the number of rankings and their weights.
+func (e *Engine) searchFuse(rankings []searchRanking, options SearchOptions) []SearchResult {
+	start := time.Now()
+	k := e.db.config.SearchRrfK
+	if k <= 0 {
+		k = searchRrfK
+	}
+
+	var results []SearchResult
+	index := make(map[int]int)
+	best := make(map[int]float64)
+	maxScore := 0.0
+
+	for _, ranking := range rankings {
+		maxScore += ranking.weight / float64(k+1)
+
+		rank := 0
+		seen := make(map[int]bool)
+		for _, result := range ranking.results {
+			if seen[result.ArticleID] {
+				continue
+			}
+			seen[result.ArticleID] = true
+			rank++
+
+			score := ranking.weight / float64(k+rank)
+			retriever := SearchRetriever{Rank: rank, Power: result.Power, Score: score}
+			if options.Explain != nil {
+				retriever.Explain = result.explain
+			}
+
+			i, exists := index[result.ArticleID]
+			if !exists {
+				i = len(results)
+				index[result.ArticleID] = i
+				result.Power = 0
+				result.Retrievers = make(map[string]SearchRetriever)
+				results = append(results, result)
+			} else if score > best[result.ArticleID] {
+				result.Power = results[i].Power
+				result.Retrievers = results[i].Retrievers
+				if result.SectionID == 0 {
+					result.SectionID = results[i].SectionID
+					result.SectionTitle = results[i].SectionTitle
+				}
+				results[i] = result
+			} else if results[i].SectionID == 0 {
+				results[i].SectionID = result.SectionID
+				results[i].SectionTitle = result.SectionTitle
+			}
+			if score > best[result.ArticleID] {
+				best[result.ArticleID] = score
+			}
+			results[i].Power += score
+			results[i].Retrievers[ranking.name] = retriever
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Power > results[j].Power
+	})
+	for i := range results {
+		if maxScore > 0 {
+			results[i].Score = results[i].Power / maxScore
+		}
+	}
+	if options.Explain != nil {
+		options.Explain.add(e.ID, "fusion", start)
+	}
+
+	return searchPage(results, options)
+}
+
+// searchRerank reorders the top Config.SearchRerank results by the score of
+// the rerank model for the query and their section. The fused Power and
+// Score values are handed down in their original order, so that the ranking
+// still reads from the highest score and merges with other databases.
+func (e *Engine) searchRerank(ctx context.Context, query string, results []SearchResult) error {
+	depth := min(len(results), e.db.config.SearchRerank)
+	if depth < 2 {
+		return nil
+	}
+
+	documents := make([]string, depth)
+	for i := range documents {
+		content := results[i].Text
+		if results[i].SectionID > 0 {
+			var err error
+			if content, err = e.db.SectionContent(ctx, results[i].SectionID); err != nil {
+				return err
+			}
+		}
+		document := []rune(results[i].Title + "\n" + content)
+		if len(document) > searchRerankLength {
+			document = document[:searchRerankLength]
+		}
+		documents[i] = string(document)
+	}
+
+	scores, err := e.db.ai.Rerank(ctx, QueryText(query), documents)
+	if err != nil {
+		return fmt.Errorf("rerank error: %v", err)
+	}
+
+	order := make([]int, depth)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	top := append([]SearchResult(nil), results[:depth]...)
+	for position, i := range order {
+		result := top[i]
+		result.Power = top[position].Power
+		result.Score = top[position].Score
+		result.Retrievers[SearchRetrieverRerank] = SearchRetriever{Rank: position + 1, Power: scores[i], Score: result.Score}
+		results[position] = result
+	}
+
+	return nil
+}
+
+// searchPage cuts the page selected by options out of a ranking.
+func searchPage(results []SearchResult, options SearchOptions) []SearchResult {
+	if options.Offset >= len(results) {
+		return nil
+	}
+	results = results[options.Offset:]
+	if len(results) > options.Limit {
+		results = results[:options.Limit]
+	}
+	return results
+}
+
+type searchTraceKey struct{}
+
+type searchTracer struct {
+	trace *SearchTrace
+	db    string
+}
+
+// searchTraced returns ctx carrying trace, for the stages of the search on
+// database db to be timed down to the database layer.
+func searchTraced(ctx context.Context, trace *SearchTrace, db string) context.Context {
+	if trace == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, searchTraceKey{}, searchTracer{trace, db})
+}
+
+// searchStage records the time elapsed since start as the stage name of the
+// search traced by ctx, if any.
+func searchStage(ctx context.Context, name string, start time.Time) {
+	if tracer, ok := ctx.Value(searchTraceKey{}).(searchTracer); ok {
+		tracer.trace.add(tracer.db, name, start)
+	}
+}
+
+func (t *SearchTrace) add(db string, name string, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Stages = append(t.Stages, SearchStage{DB: db, Name: name, Time: time.Since(start).Seconds()})
+}
@@ -0,0 +1,218 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func searchTestEngine(k int) *Engine {
+	return &Engine{ID: "test", db: &DBHandler{config: &Config{SearchRrfK: k}}}
+}
+
+func searchTestRanking(name string, weight float64, articleIDs ...int) searchRanking {
+	ranking := searchRanking{name: name, weight: weight}
+	for _, id := range articleIDs {
+		ranking.results = append(ranking.results, SearchResult{ArticleID: id})
+	}
+	return ranking
+}
+
+func TestSearchFuse(t *testing.T) {
+	tests := []struct {
+		name     string
+		k        int
+		rankings []searchRanking
+		options  SearchOptions
+		want     []int
+		scores   []float64
+	}{
+		{
+			name: "shared hits first",
+			k:    60,
+			rankings: []searchRanking{
+				searchTestRanking(SearchRetrieverTitle, 1, 1, 2),
+				searchTestRanking(SearchRetrieverContent, 1, 2, 3),
+			},
+			options: SearchOptions{Limit: 10},
+			want:    []int{2, 1, 3},
+			scores:  []float64{(1.0/62 + 1.0/61) / (2.0 / 61), (1.0 / 61) / (2.0 / 61), (1.0 / 62) / (2.0 / 61)},
+		},
+		{
+			name: "weights",
+			k:    60,
+			rankings: []searchRanking{
+				searchTestRanking(SearchRetrieverTitle, 2, 1),
+				searchTestRanking(SearchRetrieverContent, 1, 2),
+			},
+			options: SearchOptions{Limit: 10},
+			want:    []int{1, 2},
+			scores:  []float64{2.0 / 3, 1.0 / 3},
+		},
+		{
+			name: "duplicate articles ranked once",
+			k:    1,
+			rankings: []searchRanking{
+				searchTestRanking(SearchRetrieverContent, 1, 1, 1, 2),
+			},
+			options: SearchOptions{Limit: 10},
+			want:    []int{1, 2},
+			scores:  []float64{1, (1.0 / 3) / (1.0 / 2)},
+		},
+		{
+			name: "default k",
+			k:    0,
+			rankings: []searchRanking{
+				searchTestRanking(SearchRetrieverTitle, 1, 1),
+				searchTestRanking(SearchRetrieverContent, 1, 2),
+			},
+			options: SearchOptions{Limit: 10},
+			want:    []int{1, 2},
+			scores:  []float64{0.5, 0.5},
+		},
+		{
+			name: "page",
+			k:    60,
+			rankings: []searchRanking{
+				searchTestRanking(SearchRetrieverContent, 1, 1, 2, 3, 4),
+			},
+			options: SearchOptions{Limit: 2, Offset: 1},
+			want:    []int{2, 3},
+		},
+		{
+			name: "page past the end",
+			k:    60,
+			rankings: []searchRanking{
+				searchTestRanking(SearchRetrieverContent, 1, 1),
+			},
+			options: SearchOptions{Limit: 2, Offset: 1},
+		},
+	}
+
+	for _, test := range tests {
+		results := searchTestEngine(test.k).searchFuse(test.rankings, test.options)
+		if len(results) != len(test.want) {
+			t.Errorf("%s: got %d results, want %d", test.name, len(results), len(test.want))
+			continue
+		}
+		for i, result := range results {
+			if result.ArticleID != test.want[i] {
+				t.Errorf("%s: result %d is article %d, want %d", test.name, i, result.ArticleID, test.want[i])
+			}
+			if test.scores != nil && math.Abs(result.Score-test.scores[i]) > 1e-9 {
+				t.Errorf("%s: result %d scores %f, want %f", test.name, i, result.Score, test.scores[i])
+			}
+		}
+	}
+}
+
+func TestSearchFuseRetrievers(t *testing.T) {
+	rankings := []searchRanking{
+		{name: SearchRetrieverTitle, weight: 1, results: []SearchResult{{ArticleID: 1, Power: -3}}},
+		{name: SearchRetrieverContent, weight: 1, results: []SearchResult{{ArticleID: 2}, {ArticleID: 1, Power: -2, SectionID: 5, SectionTitle: "History"}}},
+	}
+	results := searchTestEngine(60).searchFuse(rankings, SearchOptions{Limit: 10})
+
+	result := results[0]
+	if result.ArticleID != 1 || result.SectionID != 5 || result.SectionTitle != "History" {
+		t.Fatalf("got article %d section %d %q, want article 1 section 5 \"History\"", result.ArticleID, result.SectionID, result.SectionTitle)
+	}
+	if math.Abs(result.Power-(1.0/61+1.0/62)) > 1e-9 {
+		t.Errorf("power %f, want %f", result.Power, 1.0/61+1.0/62)
+	}
+	title, content := result.Retrievers[SearchRetrieverTitle], result.Retrievers[SearchRetrieverContent]
+	if title.Rank != 1 || title.Power != -3 || content.Rank != 2 || content.Power != -2 {
+		t.Errorf("retrievers %+v", result.Retrievers)
+	}
+}
+
+func TestSearchDepth(t *testing.T) {
+	tests := []struct {
+		options SearchOptions
+		want    int
+	}{
+		{SearchOptions{Limit: 10}, searchDepth},
+		{SearchOptions{Limit: 10, Offset: 40}, searchDepth},
+		{SearchOptions{Limit: 10, Offset: 400}, searchDepth},
+		{SearchOptions{Limit: 200, Offset: 200}, 200},
+		{SearchOptions{Limit: 10, Offset: 20, Depth: 30}, 30},
+	}
+	for _, test := range tests {
+		if got := SearchDepth(test.options); got != test.want {
+			t.Errorf("SearchDepth(%+v) = %d, want %d", test.options, got, test.want)
+		}
+	}
+}
+
+func TestSearchRun(t *testing.T) {
+	fast := func(id int) func(context.Context) ([]SearchResult, error) {
+		return func(ctx context.Context) ([]SearchResult, error) {
+			return []SearchResult{{ArticleID: id}}, nil
+		}
+	}
+	slow := func(ctx context.Context) ([]SearchResult, error) {
+		<-ctx.Done()
+		return nil, fmt.Errorf("slow retriever: %w", ctx.Err())
+	}
+	stuck := func(ctx context.Context) ([]SearchResult, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	}
+	failing := func(ctx context.Context) ([]SearchResult, error) {
+		return nil, errors.New("no such table: sections")
+	}
+
+	tests := []struct {
+		name  string
+		tasks []searchTask
+		want  []string
+		err   string
+	}{
+		{"complete", []searchTask{{"a", 1, fast(1)}, {"b", 1, fast(2)}}, []string{"a", "b"}, ""},
+		{"timeout", []searchTask{{"a", 1, slow}, {"b", 1, fast(2)}}, []string{"b"}, ErrSearchPartial.Error()},
+		{"stuck", []searchTask{{"a", 1, fast(1)}, {"b", 1, stuck}}, []string{"a"}, ErrSearchPartial.Error()},
+		{"error", []searchTask{{"a", 1, slow}, {"b", 1, failing}}, nil, "no such table: sections"},
+	}
+	for _, test := range tests {
+		e := searchTestEngine(60)
+		e.db.config.SearchTimeout = 50 * time.Millisecond
+		rankings, err := e.searchRun(context.Background(), test.tasks)
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+		}
+		var names []string
+		for _, ranking := range rankings {
+			names = append(names, ranking.name)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(test.want) {
+			t.Errorf("%s: rankings %v, want %v", test.name, names, test.want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	e := searchTestEngine(60)
+	e.db.config.SearchTimeout = time.Second
+	if _, err := e.searchRun(ctx, []searchTask{{"a", 1, slow}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSearchCopy(t *testing.T) {
+	results := []SearchResult{{ArticleID: 1, Retrievers: map[string]SearchRetriever{SearchRetrieverTitle: {Rank: 1}}}, {ArticleID: 2}}
+	copied := searchCopy(results)
+	copied[0].ArticleID = 3
+	copied[0].Retrievers[SearchRetrieverTitle] = SearchRetriever{Rank: 2}
+	copied[0].Retrievers[SearchRetrieverContent] = SearchRetriever{Rank: 1}
+	if results[0].ArticleID != 1 || len(results[0].Retrievers) != 1 || results[0].Retrievers[SearchRetrieverTitle].Rank != 1 {
+		t.Errorf("original results changed: %+v", results[0])
+	}
+	if copied[1].Retrievers != nil {
+		t.Errorf("nil retrievers copied as %v", copied[1].Retrievers)
+	}
+}
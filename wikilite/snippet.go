@@ -0,0 +1,147 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	snippetOpen     = "\x02"
+	snippetClose    = "\x03"
+	snippetEllipsis = "…"
+	snippetTokens   = 32
+	snippetMax      = 64
+)
+
+// snippetLength returns the excerpt length in words for the requested one,
+// zero meaning the default and negative the full text.
+func snippetLength(length int) int {
+	if length == 0 {
+		return snippetTokens
+	}
+	if length > snippetMax {
+		return snippetMax
+	}
+	return length
+}
+
+// snippetSelect marks the query words in text and, unless length is
+// negative, keeps only the window of length words holding most of them.
+// It mirrors FTS5 snippet() for text that is not available to the index, like
+// compressed sections, vector hits or indexes stemmed in Go, in which case
+// stem is applied to both the text and the query words.
+func snippetSelect(text string, query string, length int, stem func(string) string) string {
+	words := strings.Fields(text)
+	terms := snippetTerms(query, stem)
+
+	matches := make([]bool, len(words))
+	for i, word := range words {
+		matches[i] = snippetMatch(word, terms, stem)
+	}
+
+	start, end := 0, len(words)
+	if length >= 0 && length < len(words) {
+		best, count := 0, 0
+		for i := 0; i < len(words); i++ {
+			if matches[i] {
+				count++
+			}
+			if i >= length && matches[i-length] {
+				count--
+			}
+			if i >= length-1 && count > best {
+				best = count
+				start = i - length + 1
+			}
+		}
+		end = start + length
+	}
+
+	var marked []string
+	for i := start; i < end; i++ {
+		word := words[i]
+		if matches[i] {
+			first := strings.IndexFunc(word, snippetIsWord)
+			last := strings.LastIndexFunc(word, snippetIsWord)
+			_, size := utf8.DecodeRuneInString(word[last:])
+			word = word[:first] + snippetOpen + word[first:last+size] + snippetClose + word[last+size:]
+		}
+		marked = append(marked, word)
+	}
+
+	snippet := strings.Join(marked, " ")
+	if start > 0 {
+		snippet = snippetEllipsis + snippet
+	}
+	if end < len(words) {
+		snippet += snippetEllipsis
+	}
+
+	return snippet
+}
+
+// snippetApply sets the plain excerpt as Text and its HTML version, with the
+// marked words wrapped in <mark>, as Snippet.
+func snippetApply(result *SearchResult, marked string) {
+	result.Text = strings.NewReplacer(snippetOpen, "", snippetClose, "").Replace(marked)
+	result.Snippet = strings.NewReplacer(snippetOpen, "<mark>", snippetClose, "</mark>").Replace(html.EscapeString(marked))
+}
+
+type snippetTerm struct {
+	word   string
+	prefix bool
+}
+
+func snippetTerms(query string, stem func(string) string) []snippetTerm {
+	var terms []snippetTerm
+	for _, term := range queryParse(strings.TrimPrefix(strings.TrimSpace(query), QueryRawPrefix)) {
+		if term.negate {
+			continue
+		}
+		words := strings.Fields(term.text)
+		for i, word := range words {
+			prefix := term.prefix && i == len(words)-1
+			if prefix {
+				word = snippetWord(word, nil)
+			} else {
+				word = snippetWord(word, stem)
+			}
+			if word != "" {
+				terms = append(terms, snippetTerm{word: word, prefix: prefix})
+			}
+		}
+	}
+	return terms
+}
+
+func snippetMatch(word string, terms []snippetTerm, stem func(string) string) bool {
+	plain := snippetWord(word, nil)
+	if plain == "" {
+		return false
+	}
+	word = snippetWord(word, stem)
+	for _, term := range terms {
+		if word == term.word || (term.prefix && strings.HasPrefix(plain, term.word)) {
+			return true
+		}
+	}
+	return false
+}
+
+func snippetWord(word string, stem func(string) string) string {
+	word = strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return !snippetIsWord(r)
+	}))
+	if stem != nil && word != "" {
+		word = stem(word)
+	}
+	return word
+}
+
+func snippetIsWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,66 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnippetSelect(t *testing.T) {
+	text := "The Linux kernel is a free and open-source, monolithic, modular, multitasking, Unix-like operating system kernel. It was originally written in 1991 by Linus Torvalds for his i386-based PC."
+	mark := func(s string) string {
+		return strings.NewReplacer("[", snippetOpen, "]", snippetClose).Replace(s)
+	}
+
+	tests := []struct {
+		query  string
+		length int
+		want   string
+	}{
+		{"kernel", -1, "The Linux [kernel] is a free and open-source, monolithic, modular, multitasking, Unix-like operating system [kernel]. It was originally written in 1991 by Linus Torvalds for his i386-based PC."},
+		{"torvalds", 5, "…in 1991 by Linus [Torvalds]…"},
+		{"linu*", 4, "The [Linux] kernel is…"},
+		{`"open-source"`, 3, "…free and [open-source],…"},
+		{"-kernel linus", 3, "…1991 by [Linus]…"},
+		{"1991 linus", 6, "…originally written in [1991] by [Linus]…"},
+		{"zzz", 4, "The Linux kernel is…"},
+		{"", 100, text},
+	}
+	for _, test := range tests {
+		if got := snippetSelect(text, test.query, test.length, nil); got != mark(test.want) {
+			t.Errorf("snippetSelect(%q, %d) = %q, want %q", test.query, test.length, got, mark(test.want))
+		}
+	}
+
+	stems := map[string]string{"runs": "run", "runners": "run"}
+	stem := func(word string) string {
+		if stemmed, found := stems[word]; found {
+			return stemmed
+		}
+		return word
+	}
+	if got, want := snippetSelect("running runs runners ran", "run", -1, stem), mark("running [runs] [runners] ran"); got != want {
+		t.Errorf("stemmed snippetSelect = %q, want %q", got, want)
+	}
+}
+
+func TestSnippetApply(t *testing.T) {
+	var result SearchResult
+	snippetApply(&result, snippetSelect("a <b> & linux", "linux", -1, nil))
+	if result.Text != "a <b> & linux" {
+		t.Errorf("Text = %q", result.Text)
+	}
+	if result.Snippet != "a &lt;b&gt; &amp; <mark>linux</mark>" {
+		t.Errorf("Snippet = %q", result.Snippet)
+	}
+}
+
+func TestSnippetLength(t *testing.T) {
+	tests := map[int]int{0: snippetTokens, 10: 10, 100: snippetMax, -1: -1}
+	for length, want := range tests {
+		if got := snippetLength(length); got != want {
+			t.Errorf("snippetLength(%d) = %d, want %d", length, got, want)
+		}
+	}
+}
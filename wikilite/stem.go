@@ -0,0 +1,208 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Tokenizers of the full text indexes. TokenizerStem stems the words in Go
+// before indexing and searching, for the languages without a stemmer in
+// SQLite, while TokenizerAuto picks the best one for the database language.
+const (
+	TokenizerDefault = ""
+	TokenizerUnicode = "unicode61"
+	TokenizerPorter  = "porter"
+	TokenizerStem    = "stem"
+	TokenizerAuto    = "auto"
+)
+
+var stemmers = map[string]func(string) string{
+	"de": stemGerman,
+	"es": stemSpanish,
+	"fr": stemFrench,
+	"it": stemItalian,
+	"pt": stemPortuguese,
+}
+
+func tokenizerResolve(tokenizer string, language string) (string, error) {
+	switch tokenizer {
+	case TokenizerDefault, TokenizerUnicode, TokenizerPorter:
+		return tokenizer, nil
+	case TokenizerStem:
+		if stemmers[language] == nil {
+			return "", fmt.Errorf("no stemmer available for language %s", language)
+		}
+		return tokenizer, nil
+	case TokenizerAuto:
+		if language == "en" {
+			return TokenizerPorter, nil
+		}
+		if stemmers[language] != nil {
+			return TokenizerStem, nil
+		}
+		return TokenizerUnicode, nil
+	}
+	return "", fmt.Errorf("unknown tokenizer %s", tokenizer)
+}
+
+// tokenizerOptions returns the FTS5 table options for tokenizer, indexes
+// stemmed in Go keep no content since it would not match the original text.
+func tokenizerOptions(tokenizer string) string {
+	switch tokenizer {
+	case TokenizerUnicode:
+		return ", tokenize='unicode61 remove_diacritics 2'"
+	case TokenizerPorter:
+		return ", tokenize='porter unicode61 remove_diacritics 2'"
+	case TokenizerStem:
+		return ", tokenize='unicode61 remove_diacritics 2'"
+	}
+	return ""
+}
+
+// TextStem replaces every word of text with its stem in language, leaving
+// the text untouched when there is no stemmer for it.
+func TextStem(language string, text string) string {
+	stem := stemmers[language]
+	if stem == nil {
+		return text
+	}
+
+	var builder strings.Builder
+	word := []rune{}
+	flush := func() {
+		if len(word) > 0 {
+			original := string(word)
+			if stemmed := stem(strings.ToLower(original)); stemmed != strings.ToLower(original) {
+				builder.WriteString(stemmed)
+			} else {
+				builder.WriteString(original)
+			}
+			word = word[:0]
+		}
+	}
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) {
+			word = append(word, r)
+		} else {
+			flush()
+			builder.WriteRune(r)
+		}
+	}
+	flush()
+
+	return builder.String()
+}
+
+var stemFold = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a",
+	"è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n", "ß", "ss",
+)
+
+// stemSuffix removes the first of suffixes found at the end of word, as long
+// as at least min runes are left.
+func stemSuffix(word string, min int, suffixes ...string) (string, bool) {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(word, suffix) && len([]rune(word))-len([]rune(suffix)) >= min {
+			return strings.TrimSuffix(word, suffix), true
+		}
+	}
+	return word, false
+}
+
+// The stemmers below are light stemmers in the spirit of the Snowball ones:
+// they fold accents and strip inflectional endings, plurals, genders and the
+// most common derivational suffixes, trading some precision for speed.
+
+func stemItalian(word string) string {
+	word = stemFold.Replace(word)
+	if len([]rune(word)) < 4 {
+		return word
+	}
+	if stemmed, ok := stemSuffix(word, 4, "amente", "mente", "zione", "zioni", "mento", "menti", "ista", "iste", "isti", "ismo", "ismi"); ok {
+		word = stemmed
+	}
+	if stemmed, ok := stemSuffix(word, 3, "ia", "ie", "io", "ii", "he", "hi"); ok {
+		return stemmed
+	}
+	word, _ = stemSuffix(word, 3, "a", "e", "i", "o")
+	return word
+}
+
+func stemSpanish(word string) string {
+	word = stemFold.Replace(word)
+	if len([]rune(word)) < 4 {
+		return word
+	}
+	if stemmed, ok := stemSuffix(word, 4, "amente", "mente", "ciones", "cion", "mientos", "miento", "istas", "ista", "ismos", "ismo"); ok {
+		word = stemmed
+	}
+	if stemmed, ok := stemSuffix(word, 2, "ces"); ok {
+		return stemmed + "z"
+	}
+	word, _ = stemSuffix(word, 3, "es", "s")
+	word, _ = stemSuffix(word, 3, "a", "e", "o")
+	return word
+}
+
+func stemPortuguese(word string) string {
+	word = stemFold.Replace(word)
+	if len([]rune(word)) < 4 {
+		return word
+	}
+	if stemmed, ok := stemSuffix(word, 4, "amente", "mente", "coes", "cao", "mentos", "mento", "istas", "ista", "ismos", "ismo"); ok {
+		word = stemmed
+	}
+	if stemmed, ok := stemSuffix(word, 3, "oes", "aes"); ok {
+		return stemmed + "a"
+	}
+	if stemmed, ok := stemSuffix(word, 3, "ais", "eis", "ois"); ok {
+		return stemmed + string([]rune(word)[len([]rune(word))-3]) + "l"
+	}
+	word, _ = stemSuffix(word, 3, "s")
+	word, _ = stemSuffix(word, 3, "a", "e", "o")
+	return word
+}
+
+func stemFrench(word string) string {
+	word = stemFold.Replace(word)
+	if len([]rune(word)) < 4 {
+		return word
+	}
+	if stemmed, ok := stemSuffix(word, 4, "ement", "ements", "ations", "ation", "istes", "iste", "ismes", "isme"); ok {
+		word = stemmed
+	}
+	if stemmed, ok := stemSuffix(word, 3, "aux"); ok {
+		return stemmed + "al"
+	}
+	word, _ = stemSuffix(word, 3, "s", "x")
+	// The folded "é", "ée" and "ées" of participles leave a run of "e" that
+	// all goes, so that the forms of a participle share one stem.
+	for stemmed, ok := stemSuffix(word, 3, "e"); ok; stemmed, ok = stemSuffix(word, 3, "e") {
+		word = stemmed
+	}
+	return word
+}
+
+func stemGerman(word string) string {
+	word = stemFold.Replace(word)
+	if len([]rune(word)) < 4 {
+		return word
+	}
+	if stemmed, ok := stemSuffix(word, 4, "ungen", "ung", "heiten", "heit", "keiten", "keit", "lich", "isch"); ok {
+		word = stemmed
+	}
+	if stemmed, ok := stemSuffix(word, 3, "ern", "em", "en", "er", "es", "e"); ok {
+		word = stemmed
+	} else if strings.HasSuffix(word, "s") && strings.ContainsAny(word[len(word)-2:len(word)-1], "bdfghklmnrt") {
+		word = strings.TrimSuffix(word, "s")
+	}
+	word, _ = stemSuffix(word, 4, "est", "st")
+	return word
+}
@@ -0,0 +1,90 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import "testing"
+
+func TestStemmers(t *testing.T) {
+	tests := []struct {
+		language string
+		words    []string
+		want     string
+	}{
+		{"it", []string{"gatto", "gatti", "gatta"}, "gatt"},
+		{"it", []string{"nazione", "nazioni"}, "nazion"},
+		{"it", []string{"farmacia", "farmacie"}, "farmac"},
+		{"it", []string{"amiche", "amichi"}, "amic"},
+		{"it", []string{"rapidamente"}, "rapid"},
+		{"it", []string{"città"}, "citt"},
+		{"es", []string{"gato", "gatos"}, "gat"},
+		{"es", []string{"nación", "naciones"}, "nacion"},
+		{"es", []string{"ciudad", "ciudades"}, "ciudad"},
+		{"es", []string{"luz", "luces"}, "luz"},
+		{"es", []string{"vez", "veces"}, "vez"},
+		{"es", []string{"rápidamente"}, "rapid"},
+		{"pt", []string{"gato", "gatos"}, "gat"},
+		{"pt", []string{"nação", "nações"}, "naca"},
+		{"pt", []string{"animal", "animais"}, "animal"},
+		{"pt", []string{"papel", "papéis"}, "papel"},
+		{"fr", []string{"chat", "chats"}, "chat"},
+		{"fr", []string{"cheval", "chevaux"}, "cheval"},
+		{"fr", []string{"nation", "nations"}, "nation"},
+		{"fr", []string{"grande", "grandes"}, "grand"},
+		{"fr", []string{"année", "années"}, "ann"},
+		{"fr", []string{"agréé", "agréée", "agréés", "agréées"}, "agr"},
+		{"de", []string{"zeitung", "zeitungen"}, "zeit"},
+		{"de", []string{"kinder", "kindern"}, "kind"},
+		{"de", []string{"haus", "hauses"}, "haus"},
+		{"de", []string{"tag", "tages"}, "tag"},
+		{"de", []string{"schnell", "schnellste"}, "schnell"},
+		{"de", []string{"freiheit"}, "frei"},
+	}
+	for _, test := range tests {
+		for _, word := range test.words {
+			if got := stemmers[test.language](word); got != test.want {
+				t.Errorf("%s stem of %q = %q, want %q", test.language, word, got, test.want)
+			}
+		}
+	}
+}
+
+func TestTextStem(t *testing.T) {
+	tests := []struct {
+		language string
+		text     string
+		want     string
+	}{
+		{"it", "I Gatti neri, e le città!", "I gatt ner, e le citt!"},
+		{"de", "Die Zeitungen", "Die zeit"},
+		{"es", "luz y luces", "luz y luz"},
+		{"en", "Running cats", "Running cats"},
+		{"it", "", ""},
+	}
+	for _, test := range tests {
+		if got := TextStem(test.language, test.text); got != test.want {
+			t.Errorf("TextStem(%q, %q) = %q, want %q", test.language, test.text, got, test.want)
+		}
+	}
+}
+
+func TestTokenizerResolve(t *testing.T) {
+	tests := []struct {
+		tokenizer string
+		language  string
+		want      string
+	}{
+		{TokenizerAuto, "en", TokenizerPorter},
+		{TokenizerAuto, "it", TokenizerStem},
+		{TokenizerAuto, "ja", TokenizerUnicode},
+		{TokenizerStem, "de", TokenizerStem},
+		{TokenizerUnicode, "en", TokenizerUnicode},
+		{TokenizerStem, "en", ""},
+		{"trigram", "en", ""},
+	}
+	for _, test := range tests {
+		got, err := tokenizerResolve(test.tokenizer, test.language)
+		if got != test.want || (err != nil) != (test.want == "") {
+			t.Errorf("tokenizerResolve(%q, %q) = %q, %v, want %q", test.tokenizer, test.language, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
+
+package wikilite
+
+import "sync"
+
+type SearchResult struct {
+	DB           string                     `json:"db,omitempty"`
+	ArticleID    int                        `json:"article_id,omitempty"`
+	Title        string                     `json:"title,omitempty"`
+	SectionID    int                        `json:"section_id,omitempty"`
+	SectionTitle string                     `json:"section_title,omitempty"`
+	Text         string                     `json:"text"`
+	Snippet      string                     `json:"snippet,omitempty"`
+	Type         string                     `json:"type,omitempty"`
+	Power        float64                    `json:"power"`
+	Score        float64                    `json:"score,omitempty"`
+	Retrievers   map[string]SearchRetriever `json:"retrievers,omitempty"`
+
+	explain *SearchExplain
+}
+
+// SearchOptions tunes a search. Offset skips the first results of the
+// ranking, Snippet is the length in words of the excerpt returned as Text,
+// zero for the default and negative for the full text. Expand adds the
+// lexical ranking of the query expanded with the embedded vocabulary terms
+// closest to its words. Explain, when set, collects the timings of the search
+// stages and details every retriever of the results. Depth, see SearchDepth,
+// is the number of hits ranked by every retriever.
+type SearchOptions struct {
+	Limit   int
+	Offset  int
+	Depth   int
+	Snippet int
+	Expand  bool
+	Filter  SearchFilter
+	Explain *SearchTrace
+}
+
+// SearchFilter restricts a search, zero values meaning no restriction.
+// Retrievers keeps only the named SearchRetriever* rankings, ArticleIDs limits
+// the matched articles, while Sections, the section headings, and LevelMin and
+// LevelMax, their heading level, restrict the matched sections and so leave
+// title matches out.
+type SearchFilter struct {
+	Retrievers []string `json:"retrievers,omitempty"`
+	ArticleIDs []int    `json:"article_ids,omitempty"`
+	Sections   []string `json:"sections,omitempty"`
+	LevelMin   int      `json:"level_min,omitempty"`
+	LevelMax   int      `json:"level_max,omitempty"`
+}
+
+type SearchFacet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type SearchRetriever struct {
+	Rank    int            `json:"rank"`
+	Power   float64        `json:"power"`
+	Score   float64        `json:"score"`
+	Explain *SearchExplain `json:"explain,omitempty"`
+}
+
+// SearchExplain tells where a retriever found a result: the table scanned,
+// with Power holding its BM25 or vector distance, and for vector hits coming
+// through the ANN index the candidate rank and distance in the index and the
+// chunk and position holding it.
+type SearchExplain struct {
+	Table     string            `json:"table"`
+	SectionID int               `json:"section_id,omitempty"`
+	Ann       *SearchExplainAnn `json:"ann,omitempty"`
+}
+
+type SearchExplainAnn struct {
+	Rank     int     `json:"rank"`
+	Distance float64 `json:"distance"`
+	Chunk    int64   `json:"chunk"`
+	Position int     `json:"position"`
+}
+
+// CacheStats reports the use of an in-memory cache, HitRate being the share
+// of lookups served from it.
+type CacheStats struct {
+	Name     string  `json:"name"`
+	Entries  int     `json:"entries"`
+	Capacity int     `json:"capacity"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+// SearchTrace collects the duration of every stage of a search, safe for
+// concurrent use.
+type SearchTrace struct {
+	mu     sync.Mutex
+	Stages []SearchStage `json:"stages"`
+}
+
+// SearchStage is a timed step of a search in seconds, DB being the Engine ID.
+type SearchStage struct {
+	DB   string  `json:"db,omitempty"`
+	Name string  `json:"name"`
+	Time float64 `json:"time"`
+}
+
+// AnswerResult is a sentence answering a question, cited by its article and
+// section, with a Score from 0 to 1.
+type AnswerResult struct {
+	DB           string  `json:"db,omitempty"`
+	Text         string  `json:"text"`
+	Score        float64 `json:"score"`
+	ArticleID    int     `json:"article_id"`
+	ArticleTitle string  `json:"article_title"`
+	SectionID    int     `json:"section_id"`
+	SectionTitle string  `json:"section_title,omitempty"`
+}
+
+// AskSource is a section given to the chat model to answer a question, cited
+// in the answer by its Number in square brackets. Text is the excerpt of the
+// section the model reads.
+type AskSource struct {
+	DB           string  `json:"db,omitempty"`
+	Number       int     `json:"number"`
+	Score        float64 `json:"score"`
+	ArticleID    int     `json:"article_id"`
+	ArticleTitle string  `json:"article_title"`
+	SectionID    int     `json:"section_id"`
+	SectionTitle string  `json:"section_title,omitempty"`
+	Text         string  `json:"text"`
+}
+
+type ArticleResultSection struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+type SectionResult struct {
+	DB           string `json:"db,omitempty"`
+	ID           int    `json:"id"`
+	Title        string `json:"title"`
+	Content      string `json:"content"`
+	ArticleID    int    `json:"article_id"`
+	ArticleTitle string `json:"article_title"`
+}
+
+type ArticleLanguage struct {
+	Language string `json:"language"`
+	DB       string `json:"db"`
+	ID       int    `json:"id"`
+}
+
+type ArticleResult struct {
+	DB        string                 `json:"db,omitempty"`
+	ID        int                    `json:"id"`
+	Title     string                 `json:"title,omitempty"`
+	Entity    string                 `json:"entity,omitempty"`
+	Language  string                 `json:"language,omitempty"`
+	Languages []ArticleLanguage      `json:"languages,omitempty"`
+	Sections  []ArticleResultSection `json:"sections,omitempty"`
+}
+
+type OutputArticle struct {
+	Title   string                   `json:"title"`
+	Entity  string                   `json:"entity"`
+	Aliases []string                 `json:"aliases,omitempty"`
+	Items   []map[string]interface{} `json:"items"`
+	ID      int                      `json:"id"`
+}
+
+type InputArticle struct {
+	MainEntity struct {
+		Identifier string `json:"identifier"`
+	} `json:"main_entity"`
+	Name        string `json:"name"`
+	ArticleBody struct {
+		HTML string `json:"html"`
+	} `json:"article_body"`
+	Redirects []struct {
+		Name string `json:"name"`
+	} `json:"redirects"`
+	Identifier int `json:"identifier"`
+}
+
+type VectorDistance struct {
+	ID            int64
+	ChunkRowID    int64
+	ChunkPosition int
+	Distance      float32
+}
+
+type DBStats struct {
+	Version            string           `json:"version"`
+	Language           string           `json:"language"`
+	Tokenizer          string           `json:"tokenizer,omitempty"`
+	Model              string           `json:"model,omitempty"`
+	ModelPrefixSave    string           `json:"model_prefix_save,omitempty"`
+	ModelPrefixSearch  string           `json:"model_prefix_search,omitempty"`
+	ModelEmbedded      bool             `json:"model_embedded"`
+	ModelSize          int64            `json:"model_size"`
+	RerankModelSize    int64            `json:"rerank_model_size,omitempty"`
+	ChatModelSize      int64            `json:"chat_model_size,omitempty"`
+	EmbeddingDimension int64            `json:"embedding_dimension"`
+	AnnMode            string           `json:"ann_mode,omitempty"`
+	AnnSize            int              `json:"ann_size,omitempty"`
+	Articles           int64            `json:"articles"`
+	Sections           int64            `json:"sections"`
+	SectionsCompressed int64            `json:"sections_compressed"`
+	SectionsPlain      int64            `json:"sections_plain"`
+	Vectors            int64            `json:"vectors"`
+	ArticleVectors     int64            `json:"article_vectors"`
+	AnnVectors         int64            `json:"ann_vectors"`
+	AnnChunks          int64            `json:"ann_chunks"`
+	Vocabulary         int64            `json:"vocabulary"`
+	Size               int64            `json:"size"`
+	Tables             map[string]int64 `json:"tables,omitempty"`
+	Caches             []CacheStats     `json:"caches,omitempty"`
+}
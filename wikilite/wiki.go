@@ -1,10 +1,11 @@
 // Copyright (C) by Ubaldo Porcheddu <ubaldo@eja.it>
 
-package main
+package wikilite
 
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -16,38 +17,41 @@ import (
 	"golang.org/x/net/html"
 )
 
-func WikiImport(path string) (err error) {
+func (h *DBHandler) WikiImport(ctx context.Context, path string) (err error) {
 	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-		err = wikiRemoteImport(path)
+		err = h.wikiRemoteImport(ctx, path)
 	} else {
-		err = wikiLocalImport(path)
+		err = h.wikiLocalImport(ctx, path)
 	}
 	if err != nil {
 		return
 	}
-	if err = db.SetupPut("version", Version); err != nil {
+	if err = h.SetupPut(ctx, "version", Version); err != nil {
 		return
 	}
-	if err = db.SetupPut("language", options.language); err != nil {
+	if err = h.SetupPut(ctx, "language", h.config.Language); err != nil {
 		return
 	}
-	if err = db.Optimize(); err != nil {
+	if err = h.Optimize(ctx); err != nil {
 		return
 	}
-	if err = db.ProcessTitles(); err != nil {
+	if err = h.ProcessTitles(ctx); err != nil {
 		return
 	}
-	if err = db.ProcessContents(); err != nil {
+	if err = h.ProcessContents(ctx); err != nil {
 		return
 	}
-	if err = db.ProcessVocabulary(); err != nil {
+	if err = h.ProcessVocabulary(ctx); err != nil {
+		return
+	}
+	if err = h.ProcessSuggest(ctx); err != nil {
 		return
 	}
 
 	return
 }
 
-func wikiImportFromReader(reader io.Reader, totalSize int64) error {
+func (h *DBHandler) wikiImportFromReader(ctx context.Context, reader io.Reader, totalSize int64) error {
 	bytesRead := int64(0)
 	teeReader := io.TeeReader(reader, &byteCounter{&bytesRead})
 
@@ -58,11 +62,16 @@ func wikiImportFromReader(reader io.Reader, totalSize int64) error {
 	defer gzipReader.Close()
 
 	tarReader := tar.NewReader(gzipReader)
-	return wikiProcessTarArchive(tarReader, totalSize, &bytesRead)
+	return h.wikiProcessTarArchive(ctx, tarReader, totalSize, &bytesRead)
 }
 
-func wikiRemoteImport(url string) error {
-	resp, err := http.Get(url)
+func (h *DBHandler) wikiRemoteImport(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("error downloading file: %v", err)
 	}
@@ -73,10 +82,10 @@ func wikiRemoteImport(url string) error {
 	}
 
 	totalSize := resp.ContentLength
-	return wikiImportFromReader(resp.Body, totalSize)
+	return h.wikiImportFromReader(ctx, resp.Body, totalSize)
 }
 
-func wikiLocalImport(filePath string) error {
+func (h *DBHandler) wikiLocalImport(ctx context.Context, filePath string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("error opening file: %v", err)
@@ -89,11 +98,15 @@ func wikiLocalImport(filePath string) error {
 	}
 	totalSize := fileInfo.Size()
 
-	return wikiImportFromReader(file, totalSize)
+	return h.wikiImportFromReader(ctx, file, totalSize)
 }
 
-func wikiProcessTarArchive(tarReader *tar.Reader, totalSize int64, bytesRead *int64) error {
+func (h *DBHandler) wikiProcessTarArchive(ctx context.Context, tarReader *tar.Reader, totalSize int64, bytesRead *int64) error {
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		header, err := tarReader.Next()
 		if err == io.EOF {
 			break
@@ -105,7 +118,7 @@ func wikiProcessTarArchive(tarReader *tar.Reader, totalSize int64, bytesRead *in
 		if header.Typeflag == tar.TypeReg {
 			log.Printf("Processing file: %s\n", header.Name)
 
-			if err := wikiProcessJSONLFile(tarReader); err != nil {
+			if err := h.wikiProcessJSONLFile(ctx, tarReader); err != nil {
 				log.Printf("Error processing file %s: %v\n", header.Name, err)
 				continue // Continue with next file even if this one fails
 			}
@@ -119,7 +132,7 @@ func wikiProcessTarArchive(tarReader *tar.Reader, totalSize int64, bytesRead *in
 	return nil
 }
 
-func wikiProcessJSONLFile(reader io.Reader) error {
+func (h *DBHandler) wikiProcessJSONLFile(ctx context.Context, reader io.Reader) error {
 	jsonDecoder := json.NewDecoder(reader)
 	for {
 		var art InputArticle
@@ -136,8 +149,12 @@ func wikiProcessJSONLFile(reader io.Reader) error {
 
 		output := wikiExtractContentFromHTML(art.ArticleBody.HTML, art.MainEntity.Identifier, art.Name, art.Identifier)
 
-		if output != nil && db != nil {
-			if err := db.ArticlePut(*output); err != nil {
+		if output != nil {
+			for _, redirect := range art.Redirects {
+				output.Aliases = append(output.Aliases, redirect.Name)
+			}
+
+			if err := h.ArticlePut(ctx, *output); err != nil {
 				log.Printf("Error saving to database: %v\n", err)
 				continue
 			}